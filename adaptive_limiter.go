@@ -0,0 +1,184 @@
+package ratelimiter
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Recorder is a Limiter which learns from the outcome of the requests it lets
+// through, such as AdaptiveLimiter. Middleware records the status code and
+// latency of every allowed request with a Recorder, whichever options it was
+// created with
+type Recorder interface {
+	Limiter
+	Record(key string, status int, latency time.Duration)
+}
+
+// clocked is a limiter which measures time with a Clock. Middleware uses it
+// to measure the latency it records with a Recorder
+type clocked interface {
+	clock() Clock
+}
+
+// NewAdaptiveLimiter creates a properly initialized AdaptiveLimiter whose
+// effective limits start at limits
+func NewAdaptiveLimiter(limits []Limit) *AdaptiveLimiter {
+	sort.Sort(byDuration(limits))
+
+	a := &AdaptiveLimiter{
+		Limits:       limits,
+		Interval:     time.Second,
+		Increase:     0.05,
+		Backoff:      0.5,
+		MinRatio:     0.1,
+		MaxErrorRate: 0.1,
+		IsError:      func(status int) bool { return status >= 500 },
+		Clock:        SystemClock,
+		ratio:        1,
+	}
+	a.limiter = NewMemoryLimiter(a.scaled())
+	a.limiter.Clock = adaptiveClock{a}
+	a.limiter.Observer = ObserverFunc(func(e Event) {
+		observe(a.Observer, e)
+	})
+	return a
+}
+
+// AdaptiveLimiter is a Limiter which lowers its limits when the backend is
+// unhealthy and raises them again once it recovers. Limits are the hard
+// ceilings. The effective limits are a share of them which is adjusted once
+// every Interval with additive increase and multiplicative decrease: it grows
+// by Increase while the backend is healthy and is multiplied by Backoff when it
+// isn't, but never drops below MinRatio.
+//
+// The backend is unhealthy during an interval when more than MaxErrorRate of
+// the recorded requests failed according to IsError, when their average
+// latency was above MaxLatency or when Health returns false. Zero MaxLatency
+// and nil Health are ignored. Requests are recorded with Record, which
+// Middleware does for every allowed request. Counters are kept in memory like
+// MemoryLimiter
+type AdaptiveLimiter struct {
+	Limits       []Limit
+	Interval     time.Duration
+	Increase     float64
+	Backoff      float64
+	MinRatio     float64
+	MaxErrorRate float64
+	MaxLatency   time.Duration
+	IsError      func(status int) bool
+	Health       func() bool
+	Observer     Observer
+	Clock        Clock
+
+	mu         sync.Mutex
+	limiter    *MemoryLimiter
+	ratio      float64
+	requests   int
+	errors     int
+	latency    time.Duration
+	nextAdjust time.Time
+}
+
+// adaptiveClock makes the MemoryLimiter of an AdaptiveLimiter use its Clock
+type adaptiveClock struct {
+	a *AdaptiveLimiter
+}
+
+func (c adaptiveClock) Now() time.Time {
+	return c.a.Clock.Now()
+}
+
+func (c adaptiveClock) After(d time.Duration) <-chan time.Time {
+	return c.a.Clock.After(d)
+}
+
+// Limit checks an IP address against the effective limits. It returns true
+// if the IP address should be ratelimited and false otherwise
+func (a *AdaptiveLimiter) Limit(ip string) bool {
+	a.mu.Lock()
+	a.adjust(a.Clock.Now())
+	a.mu.Unlock()
+
+	return a.limiter.Limit(ip)
+}
+
+// Record records the outcome of a request which was let through
+func (a *AdaptiveLimiter) Record(ip string, status int, latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.requests++
+	if a.IsError != nil && a.IsError(status) {
+		a.errors++
+	}
+	a.latency += latency
+	a.adjust(a.Clock.Now())
+}
+
+func (a *AdaptiveLimiter) clock() Clock {
+	return clockOrSystem(a.Clock)
+}
+
+// Effective returns the limits which are currently enforced
+func (a *AdaptiveLimiter) Effective() []Limit {
+	a.limiter.mu.Lock()
+	defer a.limiter.mu.Unlock()
+
+	return append([]Limit(nil), a.limiter.Limits...)
+}
+
+// adjust moves the effective limits once an interval has passed. a.mu must
+// be held
+func (a *AdaptiveLimiter) adjust(now time.Time) {
+	if a.nextAdjust.IsZero() {
+		a.nextAdjust = now.Add(a.Interval)
+		return
+	}
+	if now.Before(a.nextAdjust) {
+		return
+	}
+
+	if a.healthy() {
+		a.ratio = math.Min(1, a.ratio+a.Increase)
+	} else {
+		a.ratio = math.Max(a.MinRatio, a.ratio*a.Backoff)
+	}
+	a.requests, a.errors, a.latency = 0, 0, 0
+	a.nextAdjust = now.Add(a.Interval)
+
+	limits := a.scaled()
+	a.limiter.mu.Lock()
+	a.limiter.Limits = limits
+	a.limiter.mu.Unlock()
+}
+
+// healthy reports whether the backend was healthy during the last interval.
+// a.mu must be held
+func (a *AdaptiveLimiter) healthy() bool {
+	if a.Health != nil && !a.Health() {
+		return false
+	}
+	if a.requests == 0 {
+		return true
+	}
+	if float64(a.errors)/float64(a.requests) > a.MaxErrorRate {
+		return false
+	}
+	return a.MaxLatency <= 0 || a.latency/time.Duration(a.requests) <= a.MaxLatency
+}
+
+// scaled returns Limits scaled down to the current ratio, ignoring rounding
+// errors of the ratio. Every limit allows at least one unit. a.mu must be held
+func (a *AdaptiveLimiter) scaled() []Limit {
+	limits := make([]Limit, len(a.Limits))
+	for i, l := range a.Limits {
+		l.Limit = int(math.Floor(float64(l.Limit)*a.ratio + 1e-9))
+		if l.Limit < 1 {
+			l.Limit = 1
+		}
+		limits[i] = l
+	}
+	return limits
+}
@@ -0,0 +1,93 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAdaptiveLimiter(now *time.Time, limits ...Limit) *AdaptiveLimiter {
+	a := NewAdaptiveLimiter(limits)
+	a.Clock = funcClock(func() time.Time { return *now })
+	// start the first interval
+	a.Limit("warmup")
+	return a
+}
+
+func TestAdaptiveLimiterBacksOffOnErrors(t *testing.T) {
+	now := time.Now()
+	a := newTestAdaptiveLimiter(&now, Limit{Dur: time.Minute, Limit: 100})
+
+	a.Record("a", 200, time.Millisecond)
+	a.Record("a", 500, time.Millisecond)
+	now = now.Add(time.Second)
+	a.Limit("a")
+
+	assert.Equal(t, []Limit{{Dur: time.Minute, Limit: 50}}, a.Effective())
+}
+
+func TestAdaptiveLimiterIncreasesAdditively(t *testing.T) {
+	now := time.Now()
+	a := newTestAdaptiveLimiter(&now, Limit{Dur: time.Minute, Limit: 100}, Limit{Dur: time.Second, Limit: 20})
+	a.Health = func() bool { return false }
+
+	now = now.Add(time.Second)
+	a.Limit("a")
+	assert.Equal(t, []Limit{{Dur: time.Minute, Limit: 50}, {Dur: time.Second, Limit: 10}}, a.Effective())
+
+	a.Health = nil
+	for i := 0; i < 2; i++ {
+		now = now.Add(time.Second)
+		a.Limit("a")
+	}
+	assert.Equal(t, []Limit{{Dur: time.Minute, Limit: 60}, {Dur: time.Second, Limit: 12}}, a.Effective())
+
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Second)
+		a.Limit("a")
+	}
+	assert.Equal(t, []Limit{{Dur: time.Minute, Limit: 100}, {Dur: time.Second, Limit: 20}}, a.Effective(), "limits are the ceilings")
+}
+
+func TestAdaptiveLimiterNeverDropsBelowMinRatio(t *testing.T) {
+	now := time.Now()
+	a := newTestAdaptiveLimiter(&now, Limit{Dur: time.Minute, Limit: 100}, Limit{Dur: time.Minute, Limit: 5, Global: true})
+	a.Health = func() bool { return false }
+
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		a.Limit("a")
+	}
+
+	assert.Equal(t, []Limit{{Dur: time.Minute, Limit: 10}, {Dur: time.Minute, Limit: 1, Global: true}}, a.Effective())
+}
+
+func TestAdaptiveLimiterBacksOffOnLatency(t *testing.T) {
+	now := time.Now()
+	a := newTestAdaptiveLimiter(&now, Limit{Dur: time.Minute, Limit: 10})
+	a.MaxLatency = 100 * time.Millisecond
+
+	a.Record("a", 200, 50*time.Millisecond)
+	a.Record("a", 200, 50*time.Millisecond)
+	now = now.Add(time.Second)
+	a.Limit("a")
+	assert.Equal(t, 10, a.Effective()[0].Limit)
+
+	a.Record("a", 200, 50*time.Millisecond)
+	a.Record("a", 200, 250*time.Millisecond)
+	now = now.Add(time.Second)
+	a.Limit("a")
+	assert.Equal(t, 5, a.Effective()[0].Limit)
+}
+
+func TestAdaptiveLimiterEnforcesEffectiveLimit(t *testing.T) {
+	now := time.Now()
+	a := newTestAdaptiveLimiter(&now, Limit{Dur: time.Minute, Limit: 4})
+	a.Health = func() bool { return false }
+	now = now.Add(time.Second)
+
+	assert.False(t, a.Limit("a"))
+	assert.False(t, a.Limit("a"))
+	assert.True(t, a.Limit("a"))
+}
@@ -0,0 +1,50 @@
+package ratelimiter
+
+import "time"
+
+// Admin inspects and manages the state a limiter keeps for its keys
+type Admin interface {
+	// Status returns the usage of key for every limit
+	Status(key string) (Status, error)
+	// Reset clears the counters of key
+	Reset(key string) error
+	// ResetAll clears the counters of every key
+	ResetAll() error
+	// Ban limits key for dur regardless of its usage
+	Ban(key string, dur time.Duration) error
+	// Unban lifts the ban of key
+	Unban(key string) error
+}
+
+// Status is the state of a key returned by Admin.Status
+type Status struct {
+	Key string
+	// Banned is how long the key remains banned or zero if it isn't banned
+	Banned time.Duration
+	Limits []LimitStatus
+}
+
+// LimitStatus is the usage of a key for a single limit
+type LimitStatus struct {
+	Limit Limit
+	// Count is the number of units consumed in the current window
+	Count int
+	// Remaining is the number of units left in the current window
+	Remaining int
+	// Reset is how long until the current window ends
+	Reset time.Duration
+}
+
+func newLimitStatus(l Limit, count int, reset time.Duration) LimitStatus {
+	remaining := l.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return LimitStatus{
+		Limit:     l,
+		Count:     count,
+		Remaining: remaining,
+		Reset:     reset,
+	}
+}
@@ -0,0 +1,129 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NewAdminHandler creates an http.Handler which exposes a as a small JSON API.
+// Paths are relative to where the handler is mounted so it is usually wrapped
+// with http.StripPrefix
+//
+//    GET  /status?key=<key>
+//    POST /reset?key=<key>
+//    POST /reset-all
+//    POST /ban?key=<key>&dur=<duration>
+//    POST /unban?key=<key>
+//
+// The handler doesn't authenticate requests and must be protected by the caller
+func NewAdminHandler(a Admin) http.Handler {
+	return &adminHandler{admin: a}
+}
+
+type adminHandler struct {
+	admin Admin
+}
+
+type statusResponse struct {
+	Key           string                `json:"key"`
+	BannedSeconds float64               `json:"banned_seconds"`
+	Limits        []limitStatusResponse `json:"limits"`
+}
+
+type limitStatusResponse struct {
+	Limit        string  `json:"limit"`
+	Count        int     `json:"count"`
+	Remaining    int     `json:"remaining"`
+	ResetSeconds float64 `json:"reset_seconds"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// adminActions are the methods of the actions of the admin handler
+var adminActions = map[string]string{
+	"status":    http.MethodGet,
+	"reset":     http.MethodPost,
+	"reset-all": http.MethodPost,
+	"ban":       http.MethodPost,
+	"unban":     http.MethodPost,
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(r.URL.Path, "/")
+	method, ok := adminActions[action]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+		return
+	}
+	if r.Method != method {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+
+	if action == "reset-all" {
+		h.respond(w, h.admin.ResetAll())
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "missing key"})
+		return
+	}
+
+	switch action {
+	case "status":
+		status, err := h.admin.Status(key)
+		if err != nil {
+			h.respond(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newStatusResponse(status))
+	case "reset":
+		h.respond(w, h.admin.Reset(key))
+	case "ban":
+		dur, err := time.ParseDuration(r.URL.Query().Get("dur"))
+		if err != nil || dur <= 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid dur"})
+			return
+		}
+		h.respond(w, h.admin.Ban(key, dur))
+	case "unban":
+		h.respond(w, h.admin.Unban(key))
+	}
+}
+
+func (h *adminHandler) respond(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newStatusResponse(s Status) statusResponse {
+	res := statusResponse{
+		Key:           s.Key,
+		BannedSeconds: s.Banned.Seconds(),
+		Limits:        make([]limitStatusResponse, 0, len(s.Limits)),
+	}
+	for _, l := range s.Limits {
+		res.Limits = append(res.Limits, limitStatusResponse{
+			Limit:        l.Limit.String(),
+			Count:        l.Count,
+			Remaining:    l.Remaining,
+			ResetSeconds: l.Reset.Seconds(),
+		})
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,114 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandlerStatus(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 10}})
+	l.Clock = funcClock(func() time.Time { return now })
+	l.Limit("a")
+	h := NewAdminHandler(l)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/status?key=a", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var res statusResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, "a", res.Key)
+	require.Len(t, res.Limits, 1)
+	assert.Equal(t, "10/1m0s", res.Limits[0].Limit)
+	assert.Equal(t, 1, res.Limits[0].Count)
+	assert.Equal(t, 9, res.Limits[0].Remaining)
+	assert.Equal(t, 60.0, res.Limits[0].ResetSeconds)
+}
+
+func TestAdminHandlerBanAndUnban(t *testing.T) {
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 10}})
+	h := NewAdminHandler(l)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/ban?key=a&dur=10m", nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.True(t, l.Limit("a"))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/unban?key=a", nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, l.Limit("a"))
+}
+
+func TestAdminHandlerReset(t *testing.T) {
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}})
+	l.Limit("a")
+	l.Limit("b")
+	h := NewAdminHandler(l)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/reset?key=a", nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, l.Limit("a"))
+	assert.True(t, l.Limit("b"))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/reset-all", nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, l.Limit("b"))
+}
+
+func TestAdminHandlerRejectsBadRequests(t *testing.T) {
+	h := NewAdminHandler(NewMemoryLimiter(nil))
+
+	tests := map[string]struct {
+		method, target string
+		code           int
+	}{
+		"missing key":              {"GET", "/status", http.StatusBadRequest},
+		"wrong method":             {"GET", "/reset?key=a", http.StatusMethodNotAllowed},
+		"invalid dur":              {"POST", "/ban?key=a&dur=asdf", http.StatusBadRequest},
+		"negative dur":             {"POST", "/ban?key=a&dur=-1m", http.StatusBadRequest},
+		"unknown path":             {"POST", "/asdf?key=a", http.StatusNotFound},
+		"unknown path without key": {"POST", "/asdf", http.StatusNotFound},
+		"unknown path with GET":    {"GET", "/asdf", http.StatusNotFound},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(test.method, test.target, nil))
+			assert.Equal(t, test.code, w.Code)
+		})
+	}
+}
+
+func TestAdminHandlerReportsErrors(t *testing.T) {
+	h := NewAdminHandler(&fakeAdmin{err: errors.New("boom")})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/reset?key=a", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	var res errorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(t, "boom", res.Error)
+}
+
+type fakeAdmin struct {
+	err error
+}
+
+func (f *fakeAdmin) Status(string) (Status, error)   { return Status{}, f.err }
+func (f *fakeAdmin) Reset(string) error              { return f.err }
+func (f *fakeAdmin) ResetAll() error                 { return f.err }
+func (f *fakeAdmin) Ban(string, time.Duration) error { return f.err }
+func (f *fakeAdmin) Unban(string) error              { return f.err }
@@ -0,0 +1,20 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLimitStatusComputesRemaining(t *testing.T) {
+	l := Limit{Dur: time.Minute, Limit: 10}
+
+	s := newLimitStatus(l, 3, time.Second)
+	assert.Equal(t, LimitStatus{Limit: l, Count: 3, Remaining: 7, Reset: time.Second}, s)
+}
+
+func TestNewLimitStatusNeverReturnsNegativeRemaining(t *testing.T) {
+	s := newLimitStatus(Limit{Dur: time.Minute, Limit: 1}, 3, time.Second)
+	assert.Zero(t, s.Remaining)
+}
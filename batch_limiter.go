@@ -0,0 +1,192 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var flush = redis.NewScript(1, `
+local count = redis.call("INCRBY", KEYS[1], ARGV[1])
+if redis.call("PTTL", KEYS[1]) < 0 then
+    redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return {count, redis.call("PTTL", KEYS[1])}`)
+
+// NewBatchLimiter creates a properly initialized BatchLimiter which flushes
+// at least every second and allows each instance to go over a limit by 1% of
+// the limit
+func NewBatchLimiter(pool redisPool, limits []Limit) *BatchLimiter {
+	// limits must be sorted by TTL descending so that smaller limits don't
+	// short circuit the longer ones
+	sort.Sort(byDuration(limits))
+
+	return &BatchLimiter{
+		Pool:          pool,
+		Limits:        limits,
+		FlushInterval: time.Second,
+		MaxOvershoot:  DefaultOvershoot,
+		batches:       make(map[string]*batch),
+		Clock:         SystemClock,
+	}
+}
+
+// DefaultOvershoot allows an instance to go over l by 1% of l.Limit
+func DefaultOvershoot(l Limit) int {
+	if l.Limit < 100 {
+		return 1
+	}
+	return l.Limit / 100
+}
+
+// BatchLimiter is a rate limit which counts requests locally and flushes
+// them to Redis in batches. It trades accuracy for fewer round trips: every
+// instance decides with the count it received from Redis at its last flush
+// plus its own unflushed requests.
+//
+// Unflushed requests of a limit are flushed once there are MaxOvershoot of
+// them or FlushInterval has passed since the last flush. Flushes happen during
+// a check, so a limit is at most exceeded by MaxOvershoot requests per
+// instance plus whatever the instances allow between flushes
+type BatchLimiter struct {
+	Pool          redisPool
+	Limits        []Limit
+	FlushInterval time.Duration
+	MaxOvershoot  func(l Limit) int
+	OnError       func(ip string, err error)
+	Observer      Observer
+	Clock         Clock
+
+	mu        sync.Mutex
+	batches   map[string]*batch
+	nextSweep time.Time
+}
+
+// batch holds the local view of a counter in Redis
+type batch struct {
+	key       string
+	limit     Limit
+	synced    int
+	pending   int
+	reset     time.Time
+	lastFlush time.Time
+}
+
+// Limit checks an IP address to see if it should be ratelimited. It returns
+// true if the IP address should be ratelimited and false otherwise
+func (l *BatchLimiter) Limit(ip string) bool {
+	limited, _, _ := l.LimitRetry(ip)
+	return limited
+}
+
+// LimitRetry checks an IP address the same way as Limit but also returns how
+// long the IP address must wait before it will be allowed again. Errors are
+// reported to OnError and the check is decided with the local counts so the
+// error is always nil
+func (l *BatchLimiter) LimitRetry(ip string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Clock.Now()
+	l.sweep(now)
+
+	remaining := -1
+	for _, limit := range l.Limits {
+		b := l.batch(ip, limit, now)
+		if b.synced+b.pending >= limit.Limit {
+			retry := b.reset.Sub(now)
+			observe(l.Observer, Event{Type: EventDeny, Key: ip, Limit: limit, RetryAfter: retry})
+			return true, retry, nil
+		}
+
+		b.pending++
+		if b.pending >= l.MaxOvershoot(limit) || now.Sub(b.lastFlush) >= l.FlushInterval {
+			if err := l.flush(b, now); err != nil {
+				l.reportError(ip, err)
+			}
+		}
+		if left := limit.Limit - b.synced - b.pending; remaining < 0 || left < remaining {
+			remaining = left
+		}
+	}
+
+	observe(l.Observer, Event{Type: EventAllow, Key: ip, Remaining: remaining})
+	return false, 0, nil
+}
+
+// reportError sends err to OnError and Observer
+func (l *BatchLimiter) reportError(ip string, err error) {
+	if l.OnError != nil {
+		l.OnError(ip, err)
+	}
+	observe(l.Observer, Event{Type: EventError, Key: ip, Err: err})
+}
+
+// Flush sends every unflushed request to Redis. It should be called before
+// shutting down so that other instances see them
+func (l *BatchLimiter) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Clock.Now()
+	for _, b := range l.batches {
+		if b.pending == 0 {
+			continue
+		}
+		if err := l.flush(b, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batch returns the batch of ip for limit and starts a new window when the
+// current one has ended. l.mu must be held
+func (l *BatchLimiter) batch(ip string, limit Limit, now time.Time) *batch {
+	key := counterKey("batched", ip, limit)
+	b := l.batches[key]
+	if b == nil {
+		b = &batch{key: key, limit: limit, lastFlush: now}
+		l.batches[key] = b
+	}
+	if !now.Before(b.reset) {
+		b.synced, b.pending = 0, 0
+		b.reset = now.Add(limit.Dur)
+	}
+	return b
+}
+
+// flush adds the pending requests of b to Redis and updates b with the count
+// of every instance. l.mu must be held
+func (l *BatchLimiter) flush(b *batch, now time.Time) error {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	b.lastFlush = now
+	res, err := redis.Ints(flush.Do(con, b.key, b.pending, b.limit.Dur.Nanoseconds()/int64(time.Millisecond)))
+	if err != nil {
+		return fmt.Errorf("%s: %s", "failed to flush batch", err)
+	}
+
+	b.synced, b.pending = res[0], 0
+	if res[1] > 0 {
+		b.reset = now.Add(time.Duration(res[1]) * time.Millisecond)
+	}
+	return nil
+}
+
+// sweep removes batches whose window has ended. l.mu must be held
+func (l *BatchLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	for key, b := range l.batches {
+		if !now.Before(b.reset) {
+			delete(l.batches, key)
+		}
+	}
+	l.nextSweep = now.Add(sweepInterval)
+}
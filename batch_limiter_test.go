@@ -0,0 +1,138 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchLimiterOvershootIsBounded(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	const (
+		instances = 3
+		overshoot = 5
+	)
+	l := Limit{Dur: time.Minute, Limit: 100, Global: true}
+
+	limiters := make([]*BatchLimiter, instances)
+	for i := range limiters {
+		limiters[i] = NewBatchLimiter(&fakePool{addr: srv.Addr()}, []Limit{l})
+		limiters[i].FlushInterval = time.Hour
+		limiters[i].MaxOvershoot = func(Limit) int { return overshoot }
+	}
+
+	allowed := 0
+	for i := 0; i < 1000; i++ {
+		if !limiters[i%instances].Limit("a") {
+			allowed++
+		}
+	}
+
+	assert.True(t, allowed >= l.Limit, "allowed %d", allowed)
+	assert.True(t, allowed <= l.Limit+instances*overshoot, "allowed %d", allowed)
+}
+
+func TestBatchLimiterFlushesAfterMaxOvershoot(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := NewBatchLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 100}})
+	l.FlushInterval = time.Hour
+	l.MaxOvershoot = func(Limit) int { return 3 }
+
+	l.Limit("a")
+	l.Limit("a")
+	assert.False(t, srv.Exists("batched:a:60"))
+
+	l.Limit("a")
+	count, err := srv.Get("batched:a:60")
+	require.NoError(t, err)
+	assert.Equal(t, "3", count)
+}
+
+func TestBatchLimiterFlushesAfterFlushInterval(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	now := time.Now()
+	l := NewBatchLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 100}})
+	l.MaxOvershoot = func(Limit) int { return 10 }
+	l.Clock = funcClock(func() time.Time { return now })
+
+	l.Limit("a")
+	assert.False(t, srv.Exists("batched:a:60"))
+
+	now = now.Add(l.FlushInterval)
+	l.Limit("a")
+	count, err := srv.Get("batched:a:60")
+	require.NoError(t, err)
+	assert.Equal(t, "2", count)
+}
+
+func TestBatchLimiterFlush(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := NewBatchLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 100}})
+	l.MaxOvershoot = func(Limit) int { return 10 }
+	l.Limit("a")
+	l.Limit("b")
+	assert.Empty(t, srv.Keys())
+
+	require.NoError(t, l.Flush())
+	assert.ElementsMatch(t, []string{"batched:a:60", "batched:b:60"}, srv.Keys())
+}
+
+func TestBatchLimiterLimitsUntilWindowResets(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	now := time.Now()
+	l := NewBatchLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 2}})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	assert.False(t, l.Limit("a"))
+	assert.False(t, l.Limit("a"))
+
+	limited, retry, err := l.LimitRetry("a")
+	require.NoError(t, err)
+	assert.True(t, limited)
+	assert.Equal(t, time.Minute, retry)
+	assert.False(t, l.Limit("b"))
+
+	now = now.Add(time.Minute)
+	srv.FastForward(time.Minute)
+	assert.False(t, l.Limit("a"))
+}
+
+func TestBatchLimiterReportsErrors(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	var result string
+	l := NewBatchLimiter(&deadPool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 10}})
+	l.OnError = func(ip string, err error) {
+		assert.Error(t, err)
+		result = ip
+	}
+
+	assert.False(t, l.Limit("a"))
+	assert.Equal(t, "a", result)
+	assert.Error(t, l.Flush())
+}
+
+func TestDefaultOvershoot(t *testing.T) {
+	assert.Equal(t, 1, DefaultOvershoot(Limit{Limit: 10}))
+	assert.Equal(t, 10, DefaultOvershoot(Limit{Limit: 1000}))
+}
@@ -0,0 +1,178 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of a BreakerLimiter
+type BreakerState int
+
+const (
+	// BreakerClosed sends every check to the primary limiter
+	BreakerClosed BreakerState = iota
+	// BreakerOpen sends every check to the fallback limiter
+	BreakerOpen
+	// BreakerHalfOpen sends a single probe to the primary limiter to see if
+	// it has recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// NewBreakerLimiter creates a properly initialized BreakerLimiter which falls
+// back to a MemoryLimiter with the limits of primary divided between
+// instances. The breaker opens after 5 consecutive errors and probes primary
+// every 10 seconds while it is open
+func NewBreakerLimiter(primary *RedisLimiter, instances int) *BreakerLimiter {
+	return &BreakerLimiter{
+		Primary:       primary,
+		Fallback:      NewMemoryLimiter(ScaleLimits(primary.Limits, instances)),
+		Threshold:     5,
+		ProbeInterval: 10 * time.Second,
+		Clock:         SystemClock,
+	}
+}
+
+// BreakerLimiter is a circuit breaker around a limiter which can fail, such
+// as RedisLimiter. Checks which fail are answered by Fallback. After Threshold
+// consecutive errors the breaker opens and every check is answered by Fallback
+// until a probe, sent to Primary every ProbeInterval, succeeds
+type BreakerLimiter struct {
+	Primary       RetryLimiter
+	Fallback      RetryLimiter
+	Threshold     int
+	ProbeInterval time.Duration
+	OnError       func(key string, err error)
+	OnStateChange func(from, to BreakerState)
+	Observer      Observer
+	Clock         Clock
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	opened   uint64
+}
+
+// Limit checks key with Primary unless the breaker is open. It returns true
+// if key should be ratelimited and false otherwise
+func (b *BreakerLimiter) Limit(key string) bool {
+	limited, _, _ := b.LimitRetry(key)
+	return limited
+}
+
+// LimitRetry works like Limit but also returns how long key must wait before
+// it will be allowed again. Errors of Primary are reported to OnError and
+// only the errors of Fallback are returned
+func (b *BreakerLimiter) LimitRetry(key string) (bool, time.Duration, error) {
+	opened, ok := b.usePrimary()
+	if !ok {
+		return b.Fallback.LimitRetry(key)
+	}
+
+	limited, retry, err := b.Primary.LimitRetry(key)
+	b.record(opened, err)
+	if err != nil {
+		if b.OnError != nil {
+			b.OnError(key, err)
+		}
+		observe(b.Observer, Event{Type: EventError, Key: key, Err: err})
+		return b.Fallback.LimitRetry(key)
+	}
+	return limited, retry, nil
+}
+
+// State returns the current state of the breaker
+func (b *BreakerLimiter) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// usePrimary reports if a check should be sent to Primary. An open breaker
+// moves to half-open once ProbeInterval has passed and lets a single probe
+// through. It also returns how often the breaker has opened so far, which
+// identifies the checks started before the breaker opened again
+func (b *BreakerLimiter) usePrimary() (uint64, bool) {
+	b.mu.Lock()
+	opened := b.opened
+	switch b.state {
+	case BreakerClosed:
+		b.mu.Unlock()
+		return opened, true
+	case BreakerOpen:
+		if clockOrSystem(b.Clock).Now().Sub(b.openedAt) < b.ProbeInterval {
+			b.mu.Unlock()
+			return opened, false
+		}
+		from := b.transition(BreakerHalfOpen)
+		b.mu.Unlock()
+		b.stateChanged(from, BreakerHalfOpen)
+		return opened, true
+	default:
+		// a probe is already in flight
+		b.mu.Unlock()
+		return opened, false
+	}
+}
+
+// record updates the breaker with the result of a check sent to Primary.
+// opened is what usePrimary returned for the check. Results of checks which
+// started before the breaker last opened are ignored, so a slow success can't
+// close a breaker which opened in the meantime
+func (b *BreakerLimiter) record(opened uint64, err error) {
+	b.mu.Lock()
+	if opened != b.opened {
+		b.mu.Unlock()
+		return
+	}
+	from, to := b.state, b.state
+	switch {
+	case err == nil:
+		b.failures = 0
+		to = BreakerClosed
+	case b.state == BreakerHalfOpen:
+		to = BreakerOpen
+	default:
+		b.failures++
+		if b.failures >= b.Threshold {
+			to = BreakerOpen
+		}
+	}
+
+	if to == BreakerOpen && from != BreakerOpen {
+		b.openedAt = clockOrSystem(b.Clock).Now()
+		b.opened++
+		b.failures = 0
+	}
+	b.transition(to)
+	b.mu.Unlock()
+
+	b.stateChanged(from, to)
+}
+
+// transition moves the breaker to state and returns the previous state. b.mu
+// must be held
+func (b *BreakerLimiter) transition(state BreakerState) BreakerState {
+	from := b.state
+	b.state = state
+	return from
+}
+
+func (b *BreakerLimiter) stateChanged(from, to BreakerState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
@@ -0,0 +1,178 @@
+package ratelimiter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakerLimiterUsesFallbackOnError(t *testing.T) {
+	primary := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return false, 0, errors.New("boom")
+		},
+	}
+	b := newTestBreaker(primary)
+
+	var errs int
+	b.OnError = func(key string, err error) {
+		assert.Equal(t, "a", key)
+		errs++
+	}
+
+	assert.False(t, b.Limit("a"))
+	assert.True(t, b.Limit("a"))
+	assert.Equal(t, 2, errs)
+}
+
+func TestBreakerLimiterOpensAfterThreshold(t *testing.T) {
+	primary := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return false, 0, errors.New("boom")
+		},
+	}
+	b := newTestBreaker(primary)
+	b.Fallback = NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 100}})
+
+	var changes []BreakerState
+	b.OnStateChange = func(from, to BreakerState) {
+		changes = append(changes, to)
+	}
+
+	for i := 0; i < 5; i++ {
+		b.Limit("a")
+	}
+	assert.Equal(t, 3, primary.calls)
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.Equal(t, []BreakerState{BreakerOpen}, changes)
+}
+
+func TestBreakerLimiterClosesWhenProbeSucceeds(t *testing.T) {
+	now := time.Now()
+	fail := true
+	primary := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			if fail {
+				return false, 0, errors.New("boom")
+			}
+			return true, time.Second, nil
+		},
+	}
+	b := newTestBreaker(primary)
+	b.Fallback = NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 100}})
+	b.Clock = funcClock(func() time.Time { return now })
+
+	var changes []string
+	b.OnStateChange = func(from, to BreakerState) {
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+
+	for i := 0; i < 3; i++ {
+		b.Limit("a")
+	}
+
+	now = now.Add(b.ProbeInterval)
+	b.Limit("a")
+	assert.Equal(t, BreakerOpen, b.State())
+
+	fail = false
+	assert.False(t, b.Limit("a"), "waits for the next probe")
+	now = now.Add(b.ProbeInterval)
+	assert.True(t, b.Limit("a"))
+	assert.Equal(t, BreakerClosed, b.State())
+
+	assert.Equal(t, []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}, changes)
+}
+
+func TestBreakerLimiterResetsFailuresOnSuccess(t *testing.T) {
+	primary := &fakeRetryLimiter{
+		LimitRetryFunc: func(calls int) (bool, time.Duration, error) {
+			if calls%2 == 0 {
+				return false, 0, nil
+			}
+			return false, 0, errors.New("boom")
+		},
+	}
+	b := newTestBreaker(primary)
+
+	for i := 0; i < 10; i++ {
+		b.Limit("a")
+	}
+	assert.Equal(t, BreakerClosed, b.State())
+}
+
+func TestNewBreakerLimiterFallsBackToScaledMemoryLimiter(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	primary := NewRedisLimiter(&deadPool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 10}})
+	b := NewBreakerLimiter(primary, 4)
+
+	for i := 0; i < 3; i++ {
+		assert.False(t, b.Limit("a"))
+	}
+	assert.True(t, b.Limit("a"))
+}
+
+func TestBreakerStateString(t *testing.T) {
+	assert.Equal(t, "closed", BreakerClosed.String())
+	assert.Equal(t, "open", BreakerOpen.String())
+	assert.Equal(t, "half-open", BreakerHalfOpen.String())
+	assert.Equal(t, "unknown", BreakerState(-1).String())
+}
+
+func newTestBreaker(primary RetryLimiter) *BreakerLimiter {
+	return &BreakerLimiter{
+		Primary:       primary,
+		Fallback:      NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}}),
+		Threshold:     3,
+		ProbeInterval: time.Second,
+		Clock:         SystemClock,
+	}
+}
+
+func TestBreakerLimiterWithoutConstructor(t *testing.T) {
+	b := &BreakerLimiter{
+		Primary: &fakeRetryLimiter{
+			LimitRetryFunc: func(int) (bool, time.Duration, error) {
+				return false, 0, errors.New("boom")
+			},
+		},
+		Fallback:  &fakeRetryLimiter{},
+		Threshold: 1,
+	}
+
+	assert.False(t, b.Limit("a"))
+	assert.Equal(t, BreakerOpen, b.State())
+}
+
+func TestBreakerLimiterIgnoresResultsOfChecksStartedBeforeOpening(t *testing.T) {
+	var b *BreakerLimiter
+	primary := &fakeRetryLimiter{
+		LimitRetryFunc: func(calls int) (bool, time.Duration, error) {
+			if calls == 1 {
+				// the breaker opens while the first check is slow
+				for i := 0; i < 3; i++ {
+					b.Limit("b")
+				}
+				return false, 0, nil
+			}
+			return false, 0, errors.New("boom")
+		},
+	}
+	b = newTestBreaker(primary)
+
+	b.Limit("a")
+	assert.Equal(t, BreakerOpen, b.State())
+}
@@ -0,0 +1,60 @@
+package ratelimiter
+
+import "time"
+
+// Clock tells the time and waits for it to pass. Every limiter which keeps
+// time has a Clock field so tests can control time, see
+// ratelimitertest.FakeClock
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After returns a channel which receives the time once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock of the time package. It is the default Clock of
+// every limiter
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// clockOrSystem returns c, or SystemClock for limiters which were created
+// without their constructor and have no Clock
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// sleep blocks until d has passed on c or done is closed. It returns false
+// when done was closed first. The timer of SystemClock is stopped when sleep
+// returns so that cancelled sleeps don't keep it alive
+func sleep(c Clock, d time.Duration, done <-chan struct{}) bool {
+	if _, ok := c.(systemClock); ok {
+		t := time.NewTimer(d)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	select {
+	case <-c.After(d):
+		return true
+	case <-done:
+		return false
+	}
+}
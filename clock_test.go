@@ -0,0 +1,86 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// funcClock is a Clock which returns the time from a function so tests can
+// move it by changing a variable
+type funcClock func() time.Time
+
+func (f funcClock) Now() time.Time {
+	return f()
+}
+
+func (f funcClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// stepClock is a Clock which moves forward whenever After is called
+type stepClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *stepClock) Now() time.Time {
+	return c.now
+}
+
+func (c *stepClock) After(d time.Duration) <-chan time.Time {
+	c.slept = append(c.slept, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestSystemClock(t *testing.T) {
+	before := time.Now()
+	now := SystemClock.Now()
+	assert.False(t, now.Before(before))
+
+	select {
+	case <-SystemClock.After(time.Millisecond):
+	case <-time.After(time.Second):
+		t.Fatal("After didn't fire")
+	}
+}
+
+func TestMemoryLimiterWaitSleepsOnItsClock(t *testing.T) {
+	c := &stepClock{now: time.Now()}
+	l := NewMemoryLimiter([]Limit{{Dur: time.Hour, Limit: 1}})
+	l.Clock = c
+
+	l.Limit("a")
+	require.NoError(t, l.Wait(context.Background(), "a"))
+
+	assert.Equal(t, []time.Duration{time.Hour}, c.slept)
+}
+
+func TestSleepReturnsFalseWhenDone(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+
+	assert.False(t, sleep(SystemClock, time.Hour, done))
+	assert.False(t, sleep(funcClock(time.Now), time.Hour, done))
+}
+
+func TestSleepWaitsForClock(t *testing.T) {
+	c := &stepClock{now: time.Now()}
+
+	assert.True(t, sleep(c, time.Minute, nil))
+	assert.True(t, sleep(SystemClock, time.Millisecond, nil))
+	assert.Equal(t, []time.Duration{time.Minute}, c.slept)
+}
+
+func TestClockOrSystemDefaultsToSystemClock(t *testing.T) {
+	assert.Equal(t, SystemClock, clockOrSystem(nil))
+
+	c := &stepClock{}
+	assert.Equal(t, c, clockOrSystem(c))
+}
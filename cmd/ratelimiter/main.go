@@ -0,0 +1,464 @@
+// Command ratelimiter inspects and manages the rate limit state which
+// RedisLimiter keeps in Redis
+//
+// Usage:
+//    ratelimiter [-addr host:port] [-password pw] [-db n] <command> [args]
+//
+// Commands:
+//    list [pattern]              list the counters and bans of keys matching pattern
+//    show [-limits l,...] key    show the usage and TTL of key for every limit
+//    reset key                   clear the counters of key
+//    ban key duration            ban key for duration
+//    unban key                   lift the ban of key
+//    parse limit...              print the interpretation of limit strings
+//    simulate -limits l,... [-format csv|jsonl|clf] [-top n] file
+//                                replay an access log through limits
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/blockloop/ratelimiter"
+	"github.com/gomodule/redigo/redis"
+)
+
+// errUsage is returned when the command line can't be parsed
+var errUsage = errors.New("usage: ratelimiter [-addr host:port] [-password pw] [-db n] list|show|reset|ban|unban|parse|simulate [args]")
+
+type pool interface {
+	Get() redis.Conn
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:6379", "address of the Redis server")
+	password := flag.String("password", "", "password of the Redis server")
+	db := flag.Int("db", 0, "Redis database")
+	flag.Parse()
+
+	p := &redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", *addr,
+				redis.DialPassword(*password),
+				redis.DialDatabase(*db),
+			)
+		},
+	}
+	defer p.Close()
+
+	if err := run(flag.Args(), os.Stdout, p); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if err == errUsage {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// run executes the command in args and writes its output to stdout
+func run(args []string, stdout io.Writer, p pool) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		return list(args[1:], stdout, p)
+	case "show":
+		return show(args[1:], stdout, p)
+	case "reset":
+		return reset(args[1:], stdout, p)
+	case "ban":
+		return ban(args[1:], stdout, p)
+	case "unban":
+		return unban(args[1:], stdout, p)
+	case "parse":
+		return parse(args[1:], stdout)
+	case "simulate":
+		return simulate(args[1:], stdout)
+	default:
+		return errUsage
+	}
+}
+
+// counter is a counter of RedisLimiter stored under requests:<key>:<seconds>,
+// or requests@<name>:<key>:<seconds> for named limits
+type counter struct {
+	key    string
+	name   string
+	window time.Duration
+	count  int
+	ttl    time.Duration
+}
+
+// label returns the window of c prefixed with the name of its limit
+func (c counter) label() string {
+	if c.name != "" {
+		return c.name + ":" + c.window.String()
+	}
+	return c.window.String()
+}
+
+// counterPatterns returns the patterns of the counters of the keys matching
+// pattern, both of unnamed and named limits
+func counterPatterns(pattern string) []string {
+	return []string{"requests:" + pattern + ":*", "requests@*:" + pattern + ":*"}
+}
+
+func list(args []string, stdout io.Writer, p pool) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	pattern := "*"
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+
+	con := p.Get()
+	defer con.Close()
+
+	counters, err := scanCounters(con, counterPatterns(pattern)...)
+	if err != nil {
+		return err
+	}
+	bans, err := scan(con, "bans:"+pattern)
+	if err != nil {
+		return err
+	}
+	sort.Strings(bans)
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tWINDOW\tCOUNT\tTTL")
+	for _, c := range counters {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", c.key, c.label(), c.count, c.ttl)
+	}
+	for _, b := range bans {
+		ttl, err := pttl(con, b)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\tbanned\t-\t%s\n", strings.TrimPrefix(b, "bans:"), ttl)
+	}
+	return w.Flush()
+}
+
+func show(args []string, stdout io.Writer, p pool) error {
+	var limits ratelimiter.Limits
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Var(&limits, "limits", "limits of the key, can be repeated")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	key := fs.Arg(0)
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	if len(limits) > 0 {
+		status, err := ratelimiter.NewRedisLimiter(p, limits).Status(key)
+		if err != nil {
+			return err
+		}
+		printBan(w, status.Banned)
+		fmt.Fprintln(w, "LIMIT\tCOUNT\tREMAINING\tRESET")
+		for _, l := range status.Limits {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", l.Limit.String(), l.Count, l.Remaining, l.Reset)
+		}
+		return w.Flush()
+	}
+
+	// without limits show every window stored for the key
+	con := p.Get()
+	defer con.Close()
+
+	banned, err := pttl(con, "bans:"+key)
+	if err != nil {
+		return err
+	}
+	counters, err := scanCounters(con, counterPatterns(escapeGlob(key))...)
+	if err != nil {
+		return err
+	}
+	printBan(w, banned)
+	fmt.Fprintln(w, "WINDOW\tCOUNT\tTTL")
+	for _, c := range counters {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", c.label(), c.count, c.ttl)
+	}
+	return w.Flush()
+}
+
+func printBan(w io.Writer, banned time.Duration) {
+	if banned > 0 {
+		fmt.Fprintf(w, "banned for %s\n", banned)
+	}
+}
+
+func reset(args []string, stdout io.Writer, p pool) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	con := p.Get()
+	defer con.Close()
+
+	var keys []string
+	for _, pattern := range counterPatterns(escapeGlob(args[0])) {
+		matched, err := scan(con, pattern)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, matched...)
+	}
+	if len(keys) > 0 {
+		if _, err := con.Do("DEL", redis.Args{}.AddFlat(keys)...); err != nil {
+			return fmt.Errorf("%s: %s", "failed to reset counters", err)
+		}
+	}
+	fmt.Fprintf(stdout, "reset %d counters of %s\n", len(keys), args[0])
+	return nil
+}
+
+func ban(args []string, stdout io.Writer, p pool) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	dur, err := ratelimiter.ParseDuration(args[1])
+	if err != nil || dur <= 0 {
+		return fmt.Errorf("invalid duration %q", args[1])
+	}
+
+	if err := ratelimiter.NewRedisPenaltyStore(p).Ban(args[0], dur); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "banned %s for %s\n", args[0], dur)
+	return nil
+}
+
+func unban(args []string, stdout io.Writer, p pool) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	if err := ratelimiter.NewRedisLimiter(p, nil).Unban(args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "unbanned %s\n", args[0])
+	return nil
+}
+
+func parse(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	limits, err := ratelimiter.ParseLimits(args)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LIMIT\tNAME\tCOUNT\tWINDOW\tSCOPE\tRATE")
+	for _, l := range limits {
+		scope := "key"
+		switch {
+		case l.Global:
+			scope = "global"
+		case l.Network:
+			scope = "network"
+		}
+		name := l.Name
+		if name == "" {
+			name = "-"
+		}
+		rate := float64(l.Limit) / l.Dur.Seconds()
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s/s\n", l.String(), name, l.Limit, l.Dur, scope, formatRate(rate))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	report := ratelimiter.ValidateLimits(limits)
+	for _, issue := range report.Issues {
+		fmt.Fprintf(stdout, "warning: %s\n", issue)
+	}
+	return nil
+}
+
+func simulate(args []string, stdout io.Writer) error {
+	var limits ratelimiter.Limits
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Var(&limits, "limits", "limits to simulate, can be repeated")
+	format := fs.String("format", "", "csv, jsonl or clf, detected from the file extension by default")
+	top := fs.Int("top", 10, "number of rejected clients to print")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 || len(limits) == 0 {
+		return errUsage
+	}
+	path := fs.Arg(0)
+
+	if *format == "" {
+		*format = logFormat(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := ratelimiter.ReadLog(f, ratelimiter.LogFormat(*format))
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	res := ratelimiter.Simulate(records, limits)
+
+	fmt.Fprintf(stdout, "requests: %d\n", res.Requests)
+	fmt.Fprintf(stdout, "rejected: %d (%s%%)\n", res.Rejected, formatRate(percent(res.Rejected, res.Requests)))
+	fmt.Fprintf(stdout, "clients rejected: %d\n", len(res.Clients))
+	if len(res.RejectedBy) > 0 {
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "\nLIMIT\tREJECTED")
+		for _, l := range limits {
+			if n := res.RejectedBy[l.String()]; n > 0 {
+				fmt.Fprintf(w, "%s\t%d\n", l.String(), n)
+			}
+		}
+		fmt.Fprintln(w, "\nKEY\tREQUESTS\tREJECTED\tFIRST REJECTED")
+		for i, c := range res.Clients {
+			if i == *top {
+				break
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", c.Key, c.Requests, c.Rejected, c.FirstRejected.Format(time.RFC3339))
+		}
+		return w.Flush()
+	}
+	return nil
+}
+
+// logFormat returns the log format matching the extension of path
+func logFormat(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".csv"):
+		return string(ratelimiter.LogCSV)
+	case strings.HasSuffix(path, ".jsonl"), strings.HasSuffix(path, ".json"):
+		return string(ratelimiter.LogJSONL)
+	default:
+		return string(ratelimiter.LogCLF)
+	}
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'g', 4, 64)
+}
+
+// scanCounters returns the counters of the keys matching patterns sorted by
+// key and then by window
+func scanCounters(con redis.Conn, patterns ...string) ([]counter, error) {
+	var keys []string
+	for _, pattern := range patterns {
+		matched, err := scan(con, pattern)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, matched...)
+	}
+
+	counters := make([]counter, 0, len(keys))
+	for _, k := range keys {
+		// the key of the client may contain colons, the window and the name
+		// never do
+		i := strings.LastIndex(k, ":")
+		secs, err := strconv.ParseFloat(k[i+1:], 64)
+		if err != nil {
+			continue
+		}
+		j := strings.Index(k, ":")
+		if j >= i {
+			continue
+		}
+		var name string
+		if ns := k[:j]; ns != "requests" {
+			name = strings.TrimPrefix(ns, "requests@")
+		}
+		count, err := redis.Int(con.Do("LLEN", k))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", "failed to get count", err)
+		}
+		ttl, err := pttl(con, k)
+		if err != nil {
+			return nil, err
+		}
+		counters = append(counters, counter{
+			key:    k[j+1 : i],
+			name:   name,
+			window: time.Duration(secs * float64(time.Second)),
+			count:  count,
+			ttl:    ttl,
+		})
+	}
+
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].key != counters[j].key {
+			return counters[i].key < counters[j].key
+		}
+		if counters[i].window != counters[j].window {
+			return counters[i].window > counters[j].window
+		}
+		return counters[i].name < counters[j].name
+	})
+	return counters, nil
+}
+
+// scan returns every key matching pattern
+func scan(con redis.Conn, pattern string) ([]string, error) {
+	var all []string
+	cursor := 0
+	for {
+		res, err := redis.Values(con.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", "failed to scan keys", err)
+		}
+
+		var keys []string
+		if _, err := redis.Scan(res, &cursor, &keys); err != nil {
+			return nil, fmt.Errorf("%s: %s", "failed to scan keys", err)
+		}
+		all = append(all, keys...)
+		if cursor == 0 {
+			return all, nil
+		}
+	}
+}
+
+// pttl returns the time to live of key or zero if it doesn't exist
+func pttl(con redis.Conn, key string) (time.Duration, error) {
+	ms, err := redis.Int64(con.Do("PTTL", key))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", "failed to get ttl", err)
+	}
+	if ms < 0 {
+		return 0, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// escapeGlob escapes the characters which have a meaning in Redis patterns
+func escapeGlob(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+	return r.Replace(s)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/blockloop/ratelimiter"
+	"github.com/blockloop/ratelimiter/ratelimitertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setup(t *testing.T) (*miniredis.Miniredis, *ratelimitertest.FakePool) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+
+	p := &ratelimitertest.FakePool{Addr: srv.Addr()}
+	l := ratelimiter.NewRedisLimiter(p, ratelimiter.MustParseLimits([]string{"10/1m", "100/1h"}))
+	l.Limit("1.1.1.1")
+	l.Limit("1.1.1.1")
+	l.Limit("2.2.2.2")
+	return srv, p
+}
+
+func runOutput(t *testing.T, p pool, args ...string) string {
+	t.Helper()
+	var out bytes.Buffer
+	require.NoError(t, run(args, &out, p))
+	return out.String()
+}
+
+func TestList(t *testing.T) {
+	srv, p := setup(t)
+	defer srv.Close()
+
+	require.NoError(t, ratelimiter.NewRedisPenaltyStore(p).Ban("3.3.3.3", time.Minute))
+
+	out := runOutput(t, p, "list")
+
+	assert.Equal(t, `KEY      WINDOW  COUNT  TTL
+1.1.1.1  1h0m0s  2      1h0m0s
+1.1.1.1  1m0s    2      1m0s
+2.2.2.2  1h0m0s  1      1h0m0s
+2.2.2.2  1m0s    1      1m0s
+3.3.3.3  banned  -      1m0s
+`, out)
+}
+
+func TestListShowsNamedLimits(t *testing.T) {
+	srv, p := setup(t)
+	defer srv.Close()
+
+	ratelimiter.NewRedisLimiter(p, ratelimiter.MustParseLimits([]string{"login:5/1m"})).Limit("1.1.1.1")
+
+	out := runOutput(t, p, "list", "1.1.1.1")
+
+	assert.Equal(t, `KEY      WINDOW      COUNT  TTL
+1.1.1.1  1h0m0s      2      1h0m0s
+1.1.1.1  1m0s        2      1m0s
+1.1.1.1  login:1m0s  1      1m0s
+`, out)
+
+	out = runOutput(t, p, "reset", "1.1.1.1")
+	assert.Equal(t, "reset 3 counters of 1.1.1.1\n", out)
+}
+
+func TestListMatchesPattern(t *testing.T) {
+	srv, p := setup(t)
+	defer srv.Close()
+
+	out := runOutput(t, p, "list", "2.*")
+
+	assert.NotContains(t, out, "1.1.1.1")
+	assert.Contains(t, out, "2.2.2.2")
+}
+
+func TestShowWithLimits(t *testing.T) {
+	srv, p := setup(t)
+	defer srv.Close()
+
+	out := runOutput(t, p, "show", "-limits", "10/1m,100/1h", "1.1.1.1")
+
+	assert.Equal(t, `LIMIT       COUNT  REMAINING  RESET
+100/1h0m0s  2      98         1h0m0s
+10/1m0s     2      8          1m0s
+`, out)
+}
+
+func TestShowWithoutLimits(t *testing.T) {
+	srv, p := setup(t)
+	defer srv.Close()
+	require.NoError(t, ratelimiter.NewRedisPenaltyStore(p).Ban("1.1.1.1", time.Minute))
+
+	out := runOutput(t, p, "show", "1.1.1.1")
+
+	assert.Equal(t, `banned for 1m0s
+WINDOW  COUNT  TTL
+1h0m0s  2      1h0m0s
+1m0s    2      1m0s
+`, out)
+}
+
+func TestReset(t *testing.T) {
+	srv, p := setup(t)
+	defer srv.Close()
+
+	out := runOutput(t, p, "reset", "1.1.1.1")
+
+	assert.Equal(t, "reset 2 counters of 1.1.1.1\n", out)
+	assert.False(t, srv.Exists("requests:1.1.1.1:60"))
+	assert.False(t, srv.Exists("requests:1.1.1.1:3600"))
+	assert.True(t, srv.Exists("requests:2.2.2.2:60"))
+}
+
+func TestBanAndUnban(t *testing.T) {
+	srv, p := setup(t)
+	defer srv.Close()
+
+	assert.Equal(t, "banned 1.1.1.1 for 1h0m0s\n", runOutput(t, p, "ban", "1.1.1.1", "1h"))
+	assert.True(t, srv.Exists("bans:1.1.1.1"))
+
+	assert.Equal(t, "unbanned 1.1.1.1\n", runOutput(t, p, "unban", "1.1.1.1"))
+	assert.False(t, srv.Exists("bans:1.1.1.1"))
+}
+
+func TestBanAcceptsDurationsOfLimits(t *testing.T) {
+	srv, p := setup(t)
+	defer srv.Close()
+
+	assert.Equal(t, "banned 1.1.1.1 for 24h0m0s\n", runOutput(t, p, "ban", "1.1.1.1", "1d"))
+	assert.Equal(t, 24*time.Hour, srv.TTL("bans:1.1.1.1"))
+}
+
+func TestParse(t *testing.T) {
+	out := runOutput(t, nil, "parse", "login:5/1m", "100/1s", "10/1h/g", "50/1m/net")
+
+	assert.Equal(t, `LIMIT         NAME   COUNT  WINDOW  SCOPE    RATE
+login:5/1m0s  login  5      1m0s    key      0.08333/s
+100/1s        -      100    1s      key      100/s
+10/1h0m0s/g   -      10     1h0m0s  global   0.002778/s
+50/1m0s/net   -      50     1m0s    network  0.8333/s
+warning: 100/1s: never reached because of global limit 10/1h0m0s/g
+warning: 50/1m0s/net: never reached because of global limit 10/1h0m0s/g
+`, out)
+}
+
+func TestRunReturnsErrors(t *testing.T) {
+	srv, p := setup(t)
+	defer srv.Close()
+	tests := [][]string{
+		nil,
+		{"unknown"},
+		{"show"},
+		{"ban", "1.1.1.1"},
+		{"ban", "1.1.1.1", "forever"},
+		{"parse", "1/1y"},
+	}
+
+	for _, args := range tests {
+		assert.Error(t, run(args, &bytes.Buffer{}, p), strings.Join(args, " "))
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimiter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.csv")
+	log := "time,key\n"
+	for i := 0; i < 5; i++ {
+		log += fmt.Sprintf("%d,a\n", 1577934245+i)
+	}
+	log += "1577934245,b\n"
+	require.NoError(t, ioutil.WriteFile(path, []byte(log), 0600))
+
+	out := runOutput(t, nil, "simulate", "-limits", "3/1m", path)
+
+	assert.Equal(t, `requests: 6
+rejected: 2 (33.33%)
+clients rejected: 1
+
+LIMIT   REJECTED
+3/1m0s  2
+
+KEY  REQUESTS  REJECTED  FIRST REJECTED
+a    5         2         2020-01-02T03:04:08Z
+`, out)
+}
+
+func TestSimulateRequiresLimits(t *testing.T) {
+	assert.Equal(t, errUsage, run([]string{"simulate", "access.log"}, &bytes.Buffer{}, nil))
+}
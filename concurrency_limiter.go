@@ -0,0 +1,93 @@
+package ratelimiter
+
+import (
+	"sync"
+)
+
+// ConcurrencyLimiter caps the number of requests in flight at the same time
+// instead of the number of requests over time. It protects against slow
+// requests which a rate limit would allow to pile up
+type ConcurrencyLimiter interface {
+	// Acquire takes a slot for key. It returns false when key or all keys
+	// together have no free slots. release gives the slot back and must be
+	// called once the request finishes. Calling it more than once has no
+	// effect
+	Acquire(key string) (release func(), acquired bool)
+}
+
+// NewMemoryConcurrencyLimiter creates a properly initialized
+// MemoryConcurrencyLimiter which allows perKey requests in flight for every
+// key and global requests in flight for all keys together. Zero means no cap
+func NewMemoryConcurrencyLimiter(perKey, global int) *MemoryConcurrencyLimiter {
+	return &MemoryConcurrencyLimiter{
+		PerKey:   perKey,
+		Global:   global,
+		inFlight: make(map[string]int),
+	}
+}
+
+// MemoryConcurrencyLimiter is a ConcurrencyLimiter which counts the requests
+// in flight in memory
+type MemoryConcurrencyLimiter struct {
+	PerKey   int
+	Global   int
+	Observer Observer
+
+	mu       sync.Mutex
+	inFlight map[string]int
+	total    int
+}
+
+// Acquire takes a slot for key. See ConcurrencyLimiter
+func (l *MemoryConcurrencyLimiter) Acquire(key string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if (l.PerKey > 0 && l.inFlight[key] >= l.PerKey) || (l.Global > 0 && l.total >= l.Global) {
+		observe(l.Observer, Event{Type: EventDeny, Key: key})
+		return func() {}, false
+	}
+
+	l.inFlight[key]++
+	l.total++
+	observe(l.Observer, Event{Type: EventAllow, Key: key, Remaining: l.remaining(key)})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.release(key)
+		})
+	}, true
+}
+
+// InFlight returns the number of requests in flight for key
+func (l *MemoryConcurrencyLimiter) InFlight(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.inFlight[key]
+}
+
+func (l *MemoryConcurrencyLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.inFlight[key]--; l.inFlight[key] <= 0 {
+		delete(l.inFlight, key)
+	}
+}
+
+// remaining returns the number of free slots of key. l.mu must be held
+func (l *MemoryConcurrencyLimiter) remaining(key string) int {
+	remaining := -1
+	if l.PerKey > 0 {
+		remaining = l.PerKey - l.inFlight[key]
+	}
+	if l.Global > 0 {
+		if left := l.Global - l.total; remaining < 0 || left < remaining {
+			remaining = left
+		}
+	}
+	return remaining
+}
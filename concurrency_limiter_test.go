@@ -0,0 +1,94 @@
+package ratelimiter
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryConcurrencyLimiterCapsPerKey(t *testing.T) {
+	l := NewMemoryConcurrencyLimiter(2, 0)
+
+	_, ok := l.Acquire("a")
+	assert.True(t, ok)
+	release, ok := l.Acquire("a")
+	assert.True(t, ok)
+	_, ok = l.Acquire("a")
+	assert.False(t, ok)
+
+	_, ok = l.Acquire("b")
+	assert.True(t, ok, "other keys have their own slots")
+
+	release()
+	assert.Equal(t, 1, l.InFlight("a"))
+	_, ok = l.Acquire("a")
+	assert.True(t, ok)
+}
+
+func TestMemoryConcurrencyLimiterCapsGlobal(t *testing.T) {
+	l := NewMemoryConcurrencyLimiter(0, 2)
+
+	release, _ := l.Acquire("a")
+	l.Acquire("b")
+	_, ok := l.Acquire("c")
+	assert.False(t, ok)
+
+	release()
+	_, ok = l.Acquire("c")
+	assert.True(t, ok)
+}
+
+func TestMemoryConcurrencyLimiterReleaseIsIdempotent(t *testing.T) {
+	l := NewMemoryConcurrencyLimiter(1, 2)
+
+	release, _ := l.Acquire("a")
+	l.Acquire("b")
+	release()
+	release()
+
+	assert.Equal(t, 0, l.InFlight("a"))
+	assert.Equal(t, 1, l.InFlight("b"))
+	_, ok := l.Acquire("c")
+	assert.True(t, ok)
+	_, ok = l.Acquire("d")
+	assert.False(t, ok, "releasing twice must not free the slot of b")
+}
+
+func TestMemoryConcurrencyLimiterSendsEvents(t *testing.T) {
+	o := &recordingObserver{}
+	l := NewMemoryConcurrencyLimiter(2, 0)
+	l.Observer = o
+
+	l.Acquire("a")
+	l.Acquire("a")
+	l.Acquire("a")
+
+	assert.Equal(t, []Event{
+		{Type: EventAllow, Key: "a", Remaining: 1},
+		{Type: EventAllow, Key: "a", Remaining: 0},
+		{Type: EventDeny, Key: "a"},
+	}, o.events)
+}
+
+func TestMemoryConcurrencyLimiterConcurrent(t *testing.T) {
+	l := NewMemoryConcurrencyLimiter(10, 0)
+
+	var mu sync.Mutex
+	acquired := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := l.Acquire("a"); ok {
+				mu.Lock()
+				acquired++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, acquired)
+}
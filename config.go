@@ -0,0 +1,384 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrEmptyConfig is returned by LoadConfig when the config file is empty
+var ErrEmptyConfig = errors.New("empty config")
+
+// Config is a limiter setup loaded with LoadConfig
+type Config struct {
+	// Limiter applies the top level limits. It also applies the whitelist
+	// and denylist when requests are keyed by IP address
+	Limiter Limiter
+	// Key is the key extractor of requests which don't match a route
+	Key KeyFunc
+	// Routes are the per route rules in the order of the config file
+	Routes []Route
+	// Whitelist and Denylist are checked by Middleware against the IP
+	// address of the client whatever the key of a request is
+	Whitelist []*net.IPNet
+	Denylist  []*net.IPNet
+	// Pool is the connection pool of the redis backend and nil otherwise
+	Pool *redis.Pool
+}
+
+// Close closes the connection pool of the redis backend
+func (c *Config) Close() error {
+	if c.Pool == nil {
+		return nil
+	}
+	return c.Pool.Close()
+}
+
+// Route limits requests whose path starts with Path
+type Route struct {
+	Path    string
+	Limiter Limiter
+	Key     KeyFunc
+}
+
+// configFile is the format of a config file
+//
+// Example:
+//    backend:
+//      type: redis
+//      address: localhost:6379
+//    limits: ["100/1m", "10000/24h/g"]
+//    whitelist: ["10.0.0.0/8"]
+//    denylist: ["192.0.2.0/24"]
+//    key: ip
+//    routes:
+//      - path: /login
+//        limits: ["5/1m"]
+//      - path: /api
+//        limits: ["1000/1h"]
+//        key: header:X-API-Key
+//    strict: true
+type configFile struct {
+	Backend   configBackend `yaml:"backend"`
+	Limits    Limits        `yaml:"limits"`
+	Whitelist []configValue `yaml:"whitelist"`
+	Denylist  []configValue `yaml:"denylist"`
+	Key       configValue   `yaml:"key"`
+	Routes    []configRoute `yaml:"routes"`
+	Strict    bool          `yaml:"strict"`
+}
+
+type configBackend struct {
+	Type         configValue `yaml:"type"`
+	Address      string      `yaml:"address"`
+	Password     string      `yaml:"password"`
+	Database     int         `yaml:"database"`
+	LimitOnError *bool       `yaml:"limit_on_error"`
+}
+
+type configRoute struct {
+	Path   configValue `yaml:"path"`
+	Limits Limits      `yaml:"limits"`
+	Key    configValue `yaml:"key"`
+}
+
+// configValue is a string in a config file which remembers its line so that
+// errors can point at it
+type configValue struct {
+	Value string
+	Line  int
+}
+
+func (v *configValue) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a string", n.Line)
+	}
+	v.Value = n.Value
+	v.Line = n.Line
+	return nil
+}
+
+func (v configValue) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: "+format, append([]interface{}{v.Line}, args...)...)
+}
+
+// LoadConfig reads a YAML or JSON config file from r and builds the limiters
+// it describes. Backends are either "memory", the default, or "redis". Keys
+// are either "ip", the default, or "header:<name>". With strict set every set
+// of limits is checked with ValidateLimits
+func LoadConfig(r io.Reader) (*Config, error) {
+	var f configFile
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		if err == io.EOF {
+			return nil, ErrEmptyConfig
+		}
+		return nil, fmt.Errorf("%s: %s", "failed to parse config", err)
+	}
+
+	whitelist, err := parseConfigCIDRs(f.Whitelist)
+	if err != nil {
+		return nil, err
+	}
+	denylist, err := parseConfigCIDRs(f.Denylist)
+	if err != nil {
+		return nil, err
+	}
+	// limiters only see the key of a request, which is only an IP address
+	// for the ip key. Middleware checks the lists for every other key
+	wrap := func(l Limiter, key configValue) Limiter {
+		if !isIPKey(key) {
+			return l
+		}
+		if len(denylist) > 0 {
+			l = denylisted(l, denylist)
+		}
+		if len(whitelist) > 0 {
+			l = whitelisted(l, whitelist)
+		}
+		return l
+	}
+
+	if f.Strict {
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := parseConfigKey(f.Key)
+	if err != nil {
+		return nil, err
+	}
+	newLimiter, pool, err := f.Backend.build()
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{
+		Limiter:   wrap(newLimiter(f.Limits), f.Key),
+		Key:       key,
+		Whitelist: whitelist,
+		Denylist:  denylist,
+		Pool:      pool,
+	}
+
+	for _, rt := range f.Routes {
+		if !strings.HasPrefix(rt.Path.Value, "/") {
+			c.Close()
+			return nil, rt.Path.errorf("route path %q must start with /", rt.Path.Value)
+		}
+		rk, rkey := key, f.Key
+		if rt.Key.Value != "" {
+			var err error
+			if rk, err = parseConfigKey(rt.Key); err != nil {
+				c.Close()
+				return nil, err
+			}
+			rkey = rt.Key
+		}
+		c.Routes = append(c.Routes, Route{
+			Path:    rt.Path.Value,
+			Limiter: wrap(newLimiter(routeLimits(rt.Path.Value, rt.Limits)), rkey),
+			Key:     rk,
+		})
+	}
+
+	return c, nil
+}
+
+// Middleware limits requests with the limiter of the first route whose path
+// is a prefix of the request path and with c.Limiter otherwise. Clients in
+// the whitelist are never limited and clients in the denylist always are.
+// opts are applied to every limiter
+func (c *Config) Middleware(opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	def := Middleware(c.Limiter, append([]MiddlewareOption{WithKeyFunc(c.Key)}, opts...)...)
+	routes := make([]func(http.Handler) http.Handler, len(c.Routes))
+	for i, rt := range c.Routes {
+		routes[i] = Middleware(rt.Limiter, append([]MiddlewareOption{WithKeyFunc(rt.Key)}, opts...)...)
+	}
+
+	return func(next http.Handler) http.Handler {
+		handlers := make([]http.Handler, len(routes))
+		for i, mw := range routes {
+			handlers[i] = mw(next)
+		}
+		fallback := def(next)
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ip := KeyByIP(r)
+			if containsIP(c.Whitelist, ip) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if containsIP(c.Denylist, ip) {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+			for i, rt := range c.Routes {
+				if strings.HasPrefix(r.URL.Path, rt.Path) {
+					handlers[i].ServeHTTP(w, r)
+					return
+				}
+			}
+			fallback.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// validate checks the top level limits and the limits of every route
+func (f configFile) validate() error {
+	if err := ValidateLimits(f.Limits).Err(); err != nil {
+		return fmt.Errorf("%s: %w", "limits", err)
+	}
+	for _, rt := range f.Routes {
+		if err := ValidateLimits(rt.Limits).Err(); err != nil {
+			return rt.Path.errorf("route %s: %w", rt.Path.Value, err)
+		}
+	}
+	return nil
+}
+
+// build returns a function which creates limiters on the configured backend
+// and the connection pool of the redis backend
+func (b configBackend) build() (func([]Limit) Limiter, *redis.Pool, error) {
+	switch b.Type.Value {
+	case "", "memory":
+		return func(limits []Limit) Limiter {
+			return NewMemoryLimiter(limits)
+		}, nil, nil
+	case "redis":
+		if b.Address == "" {
+			return nil, nil, b.Type.errorf("redis backend requires an address")
+		}
+		pool := &redis.Pool{
+			MaxIdle:     3,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", b.Address,
+					redis.DialPassword(b.Password),
+					redis.DialDatabase(b.Database),
+				)
+			},
+		}
+		return func(limits []Limit) Limiter {
+			l := NewRedisLimiter(pool, limits)
+			if b.LimitOnError != nil {
+				l.LimitOnError = *b.LimitOnError
+			}
+			return l
+		}, pool, nil
+	default:
+		return nil, nil, b.Type.errorf("unknown backend %q", b.Type.Value)
+	}
+}
+
+// containsIP reports whether ip is in one of nets
+func containsIP(nets []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseConfigCIDRs(values []configValue) ([]*net.IPNet, error) {
+	cidrs := make([]*net.IPNet, len(values))
+	for i, v := range values {
+		_, c, err := net.ParseCIDR(v.Value)
+		if err != nil {
+			return nil, v.errorf("invalid CIDR %q: %s", v.Value, err)
+		}
+		cidrs[i] = c
+	}
+	return cidrs, nil
+}
+
+// isIPKey reports whether v configures the ip key
+func isIPKey(v configValue) bool {
+	return v.Value == "" || v.Value == "ip"
+}
+
+func parseConfigKey(v configValue) (KeyFunc, error) {
+	switch {
+	case isIPKey(v):
+		return KeyByIP, nil
+	case strings.HasPrefix(v.Value, "header:") && len(v.Value) > len("header:"):
+		return KeyByHeader(strings.TrimPrefix(v.Value, "header:")), nil
+	default:
+		return nil, v.errorf("unknown key %q", v.Value)
+	}
+}
+
+// whitelisted wraps l with the wrapper which keeps the Counter and Reserver
+// methods of l
+func whitelisted(l Limiter, whitelist []*net.IPNet) Limiter {
+	c, counts := l.(Counter)
+	r, reserves := l.(Reserver)
+	switch {
+	case counts && reserves:
+		w := NewWhitelistedLimiter(l, whitelist)
+		return reserveCounter{Counter: &WhitelistedCounter{w}, reserver: &WhitelistedReserver{w}}
+	case counts:
+		return NewWhitelistedCounter(c, whitelist)
+	case reserves:
+		return NewWhitelistedReserver(r, whitelist)
+	}
+	return NewWhitelistedLimiter(l, whitelist)
+}
+
+// denylisted wraps l with the wrapper which keeps the Counter and Reserver
+// methods of l
+func denylisted(l Limiter, denylist []*net.IPNet) Limiter {
+	c, counts := l.(Counter)
+	r, reserves := l.(Reserver)
+	switch {
+	case counts && reserves:
+		d := NewDenylistedLimiter(l, denylist)
+		return reserveCounter{Counter: &DenylistedCounter{d}, reserver: &DenylistedReserver{d}}
+	case counts:
+		return NewDenylistedCounter(c, denylist)
+	case reserves:
+		return NewDenylistedReserver(r, denylist)
+	}
+	return NewDenylistedLimiter(l, denylist)
+}
+
+// reserveCounter is a Counter which reserves with reserver
+type reserveCounter struct {
+	Counter
+	reserver Reserver
+}
+
+func (r reserveCounter) Reserve(key string) *Reservation {
+	return r.reserver.Reserve(key)
+}
+
+// routeLimits names the limits of the route at path so that they are counted
+// separately from the top level limits even when they share a duration. The
+// name of a named limit is kept after a # which never appears in a path
+func routeLimits(path string, limits []Limit) []Limit {
+	res := make([]Limit, len(limits))
+	for i, l := range limits {
+		if l.Name == "" {
+			l.Name = path
+		} else {
+			l.Name = path + "#" + l.Name
+		}
+		res[i] = l
+	}
+	return res
+}
@@ -0,0 +1,238 @@
+package ratelimiter
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveConfig(t *testing.T, c *Config, path, remoteAddr string, header http.Header) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", path, nil)
+	r.RemoteAddr = remoteAddr
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	c.Middleware()(&fakeHandler{}).ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestLoadConfigBuildsMemoryLimiterFromYAML(t *testing.T) {
+	c, err := LoadConfig(strings.NewReader(`
+limits: ["2/1m"]
+`))
+	require.NoError(t, err)
+
+	assert.False(t, c.Limiter.Limit("a"))
+	assert.False(t, c.Limiter.Limit("a"))
+	assert.True(t, c.Limiter.Limit("a"))
+}
+
+func TestLoadConfigBuildsRedisLimiterFromJSON(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c, err := LoadConfig(strings.NewReader(`{
+  "backend": {"type": "redis", "address": "` + srv.Addr() + `"},
+  "limits": ["1/1m"]
+}`))
+	require.NoError(t, err)
+
+	assert.False(t, c.Limiter.Limit("a"))
+	assert.True(t, c.Limiter.Limit("a"))
+	assert.True(t, srv.Exists("requests:a:60"))
+
+	require.NoError(t, c.Close())
+	assert.Equal(t, 0, c.Pool.ActiveCount())
+}
+
+func TestLoadConfigAppliesWhitelistAndDenylist(t *testing.T) {
+	c, err := LoadConfig(strings.NewReader(`
+limits: ["1/1m"]
+whitelist: ["10.0.0.0/8"]
+denylist: ["192.0.2.0/24"]
+`))
+	require.NoError(t, err)
+
+	assert.False(t, c.Limiter.Limit("10.0.0.1"))
+	assert.False(t, c.Limiter.Limit("10.0.0.1"))
+	assert.True(t, c.Limiter.Limit("192.0.2.1"))
+	assert.False(t, c.Limiter.Limit("172.16.0.1"))
+}
+
+func TestLoadConfigListsKeepCounterAndReserver(t *testing.T) {
+	c, err := LoadConfig(strings.NewReader(`
+limits: ["1/1m"]
+whitelist: ["10.0.0.0/8"]
+denylist: ["192.0.2.0/24"]
+`))
+	require.NoError(t, err)
+
+	r, ok := c.Limiter.(Reserver)
+	require.True(t, ok)
+	assert.False(t, r.Reserve("10.0.0.1").Limited)
+	assert.True(t, r.Reserve("192.0.2.1").Limited)
+	_, ok = c.Limiter.(Counter)
+	assert.True(t, ok)
+}
+
+func TestLoadConfigChecksListsAgainstClientIPWithHeaderKey(t *testing.T) {
+	c, err := LoadConfig(strings.NewReader(`
+limits: ["1/1m"]
+key: header:X-API-Key
+whitelist: ["10.0.0.0/8"]
+denylist: ["192.0.2.0/24"]
+`))
+	require.NoError(t, err)
+
+	h := http.Header{"X-Api-Key": {"abc"}}
+	assert.Equal(t, http.StatusTooManyRequests, serveConfig(t, c, "/", "192.0.2.1:1", h))
+	assert.Equal(t, http.StatusOK, serveConfig(t, c, "/", "10.0.0.1:1", h))
+	assert.Equal(t, http.StatusOK, serveConfig(t, c, "/", "10.0.0.1:1", h))
+	assert.Equal(t, http.StatusOK, serveConfig(t, c, "/", "1.1.1.1:1", h))
+	assert.Equal(t, http.StatusTooManyRequests, serveConfig(t, c, "/", "1.1.1.1:1", h))
+}
+
+func TestLoadConfigMiddlewareUsesFirstMatchingRoute(t *testing.T) {
+	c, err := LoadConfig(strings.NewReader(`
+limits: ["2/1m"]
+routes:
+  - path: /login
+    limits: ["1/1m"]
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, serveConfig(t, c, "/login", "1.1.1.1:1", nil))
+	assert.Equal(t, http.StatusTooManyRequests, serveConfig(t, c, "/login", "1.1.1.1:1", nil))
+	// the route is counted separately from the top level limits
+	assert.Equal(t, http.StatusOK, serveConfig(t, c, "/", "1.1.1.1:1", nil))
+	assert.Equal(t, http.StatusOK, serveConfig(t, c, "/", "1.1.1.1:1", nil))
+	assert.Equal(t, http.StatusTooManyRequests, serveConfig(t, c, "/", "1.1.1.1:1", nil))
+}
+
+func TestLoadConfigCountsRoutesSeparatelyOnRedis(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c, err := LoadConfig(strings.NewReader(`
+backend:
+  type: redis
+  address: ` + srv.Addr() + `
+limits: ["5/1m/g", "1000/1m/net"]
+routes:
+  - path: /login
+    limits: ["2/1m/g", "1/1m/net"]
+`))
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serveConfig(t, c, "/", "1.1.1.1:1", nil))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serveConfig(t, c, "/", "1.1.1.1:1", nil))
+
+	assert.Equal(t, http.StatusOK, serveConfig(t, c, "/login", "1.1.1.1:1", nil))
+	// the network of the route is still counted by network
+	assert.Equal(t, http.StatusTooManyRequests, serveConfig(t, c, "/login", "1.1.1.2:1", nil))
+
+	assert.True(t, srv.Exists("requests:global:60"))
+	assert.True(t, srv.Exists("requests@/login:global:60"))
+	assert.True(t, srv.Exists("requests@/login:1.1.1.0/24:60"))
+}
+
+func TestLoadConfigUsesHeaderKey(t *testing.T) {
+	c, err := LoadConfig(strings.NewReader(`
+routes:
+  - path: /api
+    limits: ["1/1m"]
+    key: header:X-API-Key
+`))
+	require.NoError(t, err)
+
+	h := http.Header{"X-Api-Key": {"abc"}}
+	assert.Equal(t, http.StatusOK, serveConfig(t, c, "/api/users", "1.1.1.1:1", h))
+	assert.Equal(t, http.StatusTooManyRequests, serveConfig(t, c, "/api/users", "2.2.2.2:1", h))
+	assert.Equal(t, http.StatusOK, serveConfig(t, c, "/api/users", "2.2.2.2:1", nil))
+}
+
+func TestLoadConfigReportsLineOfBadValues(t *testing.T) {
+	tests := []struct {
+		name, config, err string
+	}{
+		{
+			name:   "limit",
+			config: "limits:\n  - 1/1m\n  - 1/1x\n",
+			err:    `line 3: invalid limit "1/1x"`,
+		},
+		{
+			name:   "route limit",
+			config: "routes:\n  - path: /a\n    limits: [\"0/1m\"]\n",
+			err:    `line 3: invalid limit "0/1m"`,
+		},
+		{
+			name:   "whitelist",
+			config: "limits: [\"1/1m\"]\nwhitelist: [\"10.0.0.0/99\"]\n",
+			err:    `line 2: invalid CIDR "10.0.0.0/99"`,
+		},
+		{
+			name:   "denylist json",
+			config: "{\n  \"denylist\": [\n    \"nope\"\n  ]\n}",
+			err:    `line 3: invalid CIDR "nope"`,
+		},
+		{
+			name:   "backend",
+			config: "backend:\n  type: mongo\n",
+			err:    `line 2: unknown backend "mongo"`,
+		},
+		{
+			name:   "key",
+			config: "key: cookie\n",
+			err:    `line 1: unknown key "cookie"`,
+		},
+		{
+			name:   "unknown field",
+			config: "limts: [\"1/1m\"]\n",
+			err:    "line 1: field limts not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(strings.NewReader(tt.config))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestLoadConfigReturnsErrEmptyConfig(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(""))
+	assert.Equal(t, ErrEmptyConfig, err)
+}
+
+func TestLoadConfigStrictValidatesLimits(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(`
+strict: true
+limits: ["10/1m"]
+routes:
+  - path: /login
+    limits: ["5/1m", "100/1m"]
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 5: route /login")
+	assert.True(t, errors.Is(err, ErrInvalidLimits))
+
+	_, err = LoadConfig(strings.NewReader(`
+limits: ["10/1m", "10/1m"]
+`))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,153 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// NewDenyCacheLimiter creates a properly initialized DenyCacheLimiter which
+// remembers up to size denied keys and limits keys when the wrapped limiter
+// fails
+func NewDenyCacheLimiter(limiter RetryLimiter, size int) *DenyCacheLimiter {
+	return &DenyCacheLimiter{
+		Limiter:      limiter,
+		Size:         size,
+		LimitOnError: true,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+		Clock:        SystemClock,
+	}
+}
+
+// DenyCacheLimiter wraps a ratelimiter with a local cache of denied keys. A
+// key which is denied is remembered until its retry time so that further
+// checks are answered without calling the wrapped limiter, which saves a
+// round trip to Redis for clients which keep sending requests while limited.
+// At most Size keys are remembered and the least recently used are evicted
+// first
+type DenyCacheLimiter struct {
+	Limiter      RetryLimiter
+	Size         int
+	LimitOnError bool
+	OnError      func(key string, err error)
+	Observer     Observer
+	Clock        Clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	hits    uint64
+	misses  uint64
+}
+
+// CacheStats are the statistics of a DenyCacheLimiter
+type CacheStats struct {
+	// Hits is the number of checks answered by the cache
+	Hits uint64
+	// Misses is the number of checks sent to the wrapped limiter
+	Misses uint64
+	// Size is the number of keys currently in the cache
+	Size int
+}
+
+type denied struct {
+	key   string
+	until time.Time
+}
+
+// Limit returns true for keys in the cache. Otherwise it defers to
+// c.Limiter and caches the key if it is limited. Errors of c.Limiter are
+// reported to OnError and return LimitOnError
+func (c *DenyCacheLimiter) Limit(key string) bool {
+	limited, _, err := c.LimitRetry(key)
+	if err != nil {
+		if c.OnError != nil {
+			c.OnError(key, err)
+		}
+		observe(c.Observer, Event{Type: EventError, Key: key, Err: err})
+		return c.LimitOnError
+	}
+	return limited
+}
+
+// LimitRetry works like Limit but also returns how long key must wait before
+// it will be allowed again. Errors of c.Limiter are returned as is
+func (c *DenyCacheLimiter) LimitRetry(key string) (bool, time.Duration, error) {
+	if retry := c.lookup(key); retry > 0 {
+		observe(c.Observer, Event{Type: EventDeny, Key: key, RetryAfter: retry})
+		return true, retry, nil
+	}
+
+	limited, retry, err := c.Limiter.LimitRetry(key)
+	if err == nil && limited && retry > 0 {
+		c.add(key, retry)
+	}
+	return limited, retry, err
+}
+
+// Forget removes key from the cache, for example after it has been reset or
+// unbanned
+func (c *DenyCacheLimiter) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+// Stats returns the statistics of the cache
+func (c *DenyCacheLimiter) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:   c.hits,
+		Misses: c.misses,
+		Size:   c.lru.Len(),
+	}
+}
+
+// lookup returns how long key remains denied or zero if it isn't in the cache
+func (c *DenyCacheLimiter) lookup(key string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		now := c.Clock.Now()
+		d := e.Value.(*denied)
+		if now.Before(d.until) {
+			c.hits++
+			c.lru.MoveToFront(e)
+			return d.until.Sub(now)
+		}
+		c.remove(e)
+	}
+
+	c.misses++
+	return 0
+}
+
+func (c *DenyCacheLimiter) add(key string, retry time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	until := c.Clock.Now().Add(retry)
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*denied).until = until
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&denied{key: key, until: until})
+	for c.lru.Len() > c.Size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove deletes e from the cache. c.mu must be held
+func (c *DenyCacheLimiter) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*denied).key)
+}
@@ -0,0 +1,124 @@
+package ratelimiter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDenyCacheLimiterSkipsLimiterForDeniedKeys(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	c := NewDenyCacheLimiter(limiter, 10)
+	ip := uuid.New()
+
+	assert.False(t, c.Limit(ip))
+	assert.True(t, c.Limit(ip))
+	commands := srv.CommandCount()
+
+	for i := 0; i < 5; i++ {
+		limited, retry, err := c.LimitRetry(ip)
+		require.NoError(t, err)
+		assert.True(t, limited)
+		assert.True(t, retry > 0)
+	}
+
+	assert.Equal(t, commands, srv.CommandCount())
+	assert.Equal(t, CacheStats{Hits: 5, Misses: 2, Size: 1}, c.Stats())
+}
+
+func TestDenyCacheLimiterForgetsKeysAfterRetry(t *testing.T) {
+	now := time.Now()
+	fake := &fakeRetryLimiter{
+		LimitRetryFunc: func(calls int) (bool, time.Duration, error) {
+			return calls == 1, time.Minute, nil
+		},
+	}
+	c := NewDenyCacheLimiter(fake, 10)
+	c.Clock = funcClock(func() time.Time { return now })
+
+	assert.True(t, c.Limit("a"))
+	assert.True(t, c.Limit("a"))
+	now = now.Add(time.Minute)
+	assert.False(t, c.Limit("a"))
+	assert.Equal(t, 2, fake.calls)
+}
+
+func TestDenyCacheLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	fake := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return true, time.Minute, nil
+		},
+	}
+	c := NewDenyCacheLimiter(fake, 2)
+
+	c.Limit("a")
+	c.Limit("b")
+	c.Limit("a")
+	c.Limit("c")
+
+	fake.calls = 0
+	c.Limit("a")
+	c.Limit("c")
+	assert.Equal(t, 0, fake.calls)
+	c.Limit("b")
+	assert.Equal(t, 1, fake.calls)
+}
+
+func TestDenyCacheLimiterForget(t *testing.T) {
+	fake := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return true, time.Minute, nil
+		},
+	}
+	c := NewDenyCacheLimiter(fake, 10)
+
+	c.Limit("a")
+	c.Forget("a")
+	c.Limit("a")
+	assert.Equal(t, 2, fake.calls)
+}
+
+func TestDenyCacheLimiterDoesNotCacheErrors(t *testing.T) {
+	expected := errors.New("boom")
+	fake := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return true, time.Minute, expected
+		},
+	}
+	c := NewDenyCacheLimiter(fake, 10)
+
+	_, _, err := c.LimitRetry("a")
+	assert.Equal(t, expected, err)
+	assert.Zero(t, c.Stats().Size)
+}
+
+func TestDenyCacheLimiterHandlesErrorsWithoutCheckingAgain(t *testing.T) {
+	fake := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return false, 0, errors.New("boom")
+		},
+	}
+	var errKey string
+	c := NewDenyCacheLimiter(fake, 10)
+	c.OnError = func(key string, err error) {
+		errKey = key
+	}
+
+	assert.True(t, c.Limit("a"))
+	assert.Equal(t, "a", errKey)
+	assert.Equal(t, 1, fake.calls)
+	assert.Zero(t, c.Stats().Size)
+
+	c.LimitOnError = false
+	assert.False(t, c.Limit("a"))
+	assert.Equal(t, 2, fake.calls)
+}
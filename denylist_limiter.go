@@ -0,0 +1,112 @@
+package ratelimiter
+
+import (
+	"net"
+)
+
+// DenylistedLimiter wraps a ratelimiter with a denylist. IP addresses in the
+// denylist are always limited
+type DenylistedLimiter struct {
+	Limiter    Limiter
+	Denylist   []*net.IPNet
+	OnDenylist func(ip string)
+	Collector  Collector
+	Observer   Observer
+}
+
+// NewDenylistedLimiter constructs a new DenylistedLimiter
+func NewDenylistedLimiter(limiter Limiter, denylist []*net.IPNet) *DenylistedLimiter {
+	return &DenylistedLimiter{
+		Limiter:  limiter,
+		Denylist: denylist,
+	}
+}
+
+// Limit checks the denylist for denied IP addresses and then returns true if
+// any match. If none match then it defers to d.Limiter.Limit
+func (d *DenylistedLimiter) Limit(ip string) bool {
+	if d.denylisted(ip) {
+		return true
+	}
+
+	return d.Limiter.Limit(ip)
+}
+
+// NewDenylistedReserver constructs a new DenylistedReserver
+func NewDenylistedReserver(reserver Reserver, denylist []*net.IPNet) *DenylistedReserver {
+	return &DenylistedReserver{NewDenylistedLimiter(reserver, denylist)}
+}
+
+// DenylistedReserver is a DenylistedLimiter around a Reserver which is a
+// Reserver itself, so that it can be used with RefundIf. Limiter must
+// implement Reserver
+type DenylistedReserver struct {
+	*DenylistedLimiter
+}
+
+// Reserve returns a limited Reservation for denied IP addresses. If none match
+// then it defers to d.Limiter.Reserve
+func (d *DenylistedReserver) Reserve(ip string) *Reservation {
+	if d.denylisted(ip) {
+		return &Reservation{Limited: true}
+	}
+	return d.Limiter.(Reserver).Reserve(ip)
+}
+
+// NewDenylistedCounter constructs a new DenylistedCounter
+func NewDenylistedCounter(counter Counter, denylist []*net.IPNet) *DenylistedCounter {
+	return &DenylistedCounter{NewDenylistedLimiter(counter, denylist)}
+}
+
+// DenylistedCounter is a DenylistedLimiter around a Counter which is a
+// Counter itself, so that it can be used with CountIf. Limiter must implement
+// Counter
+type DenylistedCounter struct {
+	*DenylistedLimiter
+}
+
+// Peek returns true for denied IP addresses. If none match then it defers to
+// d.Limiter.Peek
+func (d *DenylistedCounter) Peek(ip string) bool {
+	if d.denylisted(ip) {
+		return true
+	}
+	return d.Limiter.(Counter).Peek(ip)
+}
+
+// Consume defers to d.Limiter.Consume for IP addresses which are not denied
+func (d *DenylistedCounter) Consume(ip string) {
+	if !d.contains(ip) {
+		d.Limiter.(Counter).Consume(ip)
+	}
+}
+
+// Reset defers to d.Limiter.Reset
+func (d *DenylistedCounter) Reset(ip string) error {
+	return d.Limiter.(Counter).Reset(ip)
+}
+
+// denylisted reports whether ip is denied and calls OnDenylist if it is
+func (d *DenylistedLimiter) denylisted(ip string) bool {
+	if !d.contains(ip) {
+		return false
+	}
+	if d.OnDenylist != nil {
+		d.OnDenylist(ip)
+	}
+	if d.Collector != nil {
+		d.Collector.Decision("", DecisionDenied)
+	}
+	observe(d.Observer, Event{Type: EventDeny, Key: ip})
+	return true
+}
+
+func (d *DenylistedLimiter) contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	for _, dl := range d.Denylist {
+		if dl.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package ratelimiter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDenylistedLimiterLimitsWhenDenylisted(t *testing.T) {
+	ip, cidr, err := net.ParseCIDR("192.168.1.100/32")
+	require.NoError(t, err)
+	fake := &fakeLimiter{
+		LimitFunc: func(string) bool {
+			t.Fatal("limiter should not be called")
+			return false
+		},
+	}
+
+	dl := NewDenylistedLimiter(fake, []*net.IPNet{cidr})
+
+	assert.True(t, dl.Limit(ip.String()))
+}
+
+func TestDenylistedReserverLimitsWhenDenylisted(t *testing.T) {
+	ip, cidr, err := net.ParseCIDR("192.168.1.100/32")
+	require.NoError(t, err)
+
+	dl := NewDenylistedReserver(NewMemoryLimiter([]Limit{MustParseLimit("1/1m")}), []*net.IPNet{cidr})
+
+	assert.True(t, dl.Reserve(ip.String()).Limited)
+	assert.False(t, dl.Reserve("192.168.1.101").Limited)
+}
+
+func TestDenylistedLimiterDefersWhenNotDenylisted(t *testing.T) {
+	_, cidr, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	var called string
+	fake := &fakeLimiter{
+		LimitFunc: func(in string) bool {
+			called = in
+			return false
+		},
+	}
+
+	dl := NewDenylistedLimiter(fake, []*net.IPNet{cidr})
+
+	assert.False(t, dl.Limit("192.168.1.100"))
+	assert.Equal(t, "192.168.1.100", called)
+}
+
+func TestDenylistedLimiterReportsDenials(t *testing.T) {
+	ip, cidr, err := net.ParseCIDR("192.168.1.100/32")
+	require.NoError(t, err)
+	var denied string
+	c := &fakeCollector{}
+	o := &recordingObserver{}
+
+	dl := NewDenylistedLimiter(&fakeLimiter{}, []*net.IPNet{cidr})
+	dl.OnDenylist = func(in string) {
+		denied = in
+	}
+	dl.Collector = c
+	dl.Observer = o
+
+	assert.True(t, dl.Limit(ip.String()))
+	assert.Equal(t, ip.String(), denied)
+	assert.Equal(t, []string{" denied"}, c.decisions)
+	assert.Equal(t, []Event{{Type: EventDeny, Key: ip.String()}}, o.events)
+}
+
+func TestDenylistedCounterConsumeSkipsDenylisted(t *testing.T) {
+	ip, cidr, err := net.ParseCIDR("192.168.1.100/32")
+	require.NoError(t, err)
+	l := NewMemoryLimiter([]Limit{MustParseLimit("1/1m")})
+
+	dl := NewDenylistedCounter(l, []*net.IPNet{cidr})
+	dl.Consume(ip.String())
+	dl.Consume("10.0.0.1")
+
+	assert.False(t, l.Peek(ip.String()))
+	assert.True(t, l.Peek("10.0.0.1"))
+}
+
+func TestDenylistedCounterPeeksDenylisted(t *testing.T) {
+	ip, cidr, err := net.ParseCIDR("192.168.1.100/32")
+	require.NoError(t, err)
+
+	dl := NewDenylistedCounter(NewMemoryLimiter([]Limit{MustParseLimit("1/1m")}), []*net.IPNet{cidr})
+
+	assert.True(t, dl.Peek(ip.String()))
+	assert.False(t, dl.Peek("10.0.0.1"))
+}
+
+func TestDenylistedLimiterOnlyCountsWithCounter(t *testing.T) {
+	var l Limiter = NewDenylistedLimiter(&fakeLimiter{}, nil)
+	_, ok := l.(Counter)
+	assert.False(t, ok)
+
+	l = NewDenylistedCounter(NewMemoryLimiter(nil), nil)
+	_, ok = l.(Counter)
+	assert.True(t, ok)
+}
+
+func TestDenylistedLimiterOnlyReservesWithReserver(t *testing.T) {
+	var l Limiter = NewDenylistedLimiter(&fakeLimiter{}, nil)
+	_, ok := l.(Reserver)
+	assert.False(t, ok, "Cancel would have nothing to give back")
+
+	l = NewDenylistedReserver(NewMemoryLimiter(nil), nil)
+	_, ok = l.(Reserver)
+	assert.True(t, ok)
+}
@@ -0,0 +1,144 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Limits is a list of limits which can be used directly in config structs
+// and as a command line flag. As text it is a comma separated list of limits
+// in the format of ParseLimit
+type Limits []Limit
+
+// MarshalText returns l.String()
+func (l Limit) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText parses text with ParseLimit
+func (l *Limit) UnmarshalText(text []byte) error {
+	parsed, err := ParseLimit(string(text))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// MarshalJSON encodes l as a JSON string
+func (l Limit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+// UnmarshalJSON parses a JSON string with ParseLimit
+func (l *Limit) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("%s: %s", "limit must be a string", err)
+	}
+	return l.UnmarshalText([]byte(s))
+}
+
+// MarshalYAML encodes l as a YAML string
+func (l Limit) MarshalYAML() (interface{}, error) {
+	return l.String(), nil
+}
+
+// UnmarshalYAML parses a YAML string with ParseLimit. Errors include the line
+// of the limit
+func (l *Limit) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: limit must be a string", n.Line)
+	}
+	if err := l.UnmarshalText([]byte(n.Value)); err != nil {
+		return fmt.Errorf("line %d: %s", n.Line, err)
+	}
+	return nil
+}
+
+// Set parses value with ParseLimit. It implements flag.Value
+func (l *Limit) Set(value string) error {
+	return l.UnmarshalText([]byte(value))
+}
+
+// String returns the limits separated by commas
+func (ls Limits) String() string {
+	s := make([]string, len(ls))
+	for i, l := range ls {
+		s[i] = l.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// MarshalText returns ls.String()
+func (ls Limits) MarshalText() ([]byte, error) {
+	return []byte(ls.String()), nil
+}
+
+// UnmarshalText parses a comma separated list of limits
+func (ls *Limits) UnmarshalText(text []byte) error {
+	*ls = nil
+	if len(text) == 0 {
+		return nil
+	}
+	return ls.Set(string(text))
+}
+
+// MarshalJSON encodes ls as a JSON array of strings
+func (ls Limits) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]Limit(ls))
+}
+
+// UnmarshalJSON parses a JSON array of limit strings
+func (ls *Limits) UnmarshalJSON(b []byte) error {
+	var limits []Limit
+	if err := json.Unmarshal(b, &limits); err != nil {
+		return err
+	}
+	*ls = limits
+	return nil
+}
+
+// MarshalYAML encodes ls as a YAML sequence of strings
+func (ls Limits) MarshalYAML() (interface{}, error) {
+	return []Limit(ls), nil
+}
+
+// UnmarshalYAML parses a YAML sequence of limit strings or a comma separated
+// string. Errors include the line of the bad limit
+func (ls *Limits) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		if err := ls.UnmarshalText([]byte(n.Value)); err != nil {
+			return fmt.Errorf("line %d: %s", n.Line, err)
+		}
+		return nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: limits must be a list", n.Line)
+	}
+
+	limits := make(Limits, len(n.Content))
+	for i, c := range n.Content {
+		if err := limits[i].UnmarshalYAML(c); err != nil {
+			return err
+		}
+	}
+	*ls = limits
+	return nil
+}
+
+// Set appends a comma separated list of limits to ls. It implements
+// flag.Value so the flag can be repeated
+func (ls *Limits) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		var l Limit
+		if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+			return err
+		}
+		*ls = append(*ls, l)
+	}
+	return nil
+}
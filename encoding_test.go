@@ -0,0 +1,127 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLimitStringRoundTripsThroughParseLimit(t *testing.T) {
+	for _, raw := range []string{"1/1s", "10/1m", "10/24h/g", "5/90m", "3/1h30m15s/g"} {
+		t.Run(raw, func(t *testing.T) {
+			l := MustParseLimit(raw)
+			parsed, err := ParseLimit(l.String())
+			require.NoError(t, err)
+			assert.Equal(t, l, parsed)
+		})
+	}
+}
+
+func TestParseLimitRejectsUnknownSuffix(t *testing.T) {
+	tests := map[string]error{
+		"1/1m/x":   ErrUnknownScope,
+		"1/1m/":    ErrUnknownScope,
+		"1/1m/g/g": ErrMalformedLimit,
+	}
+	for raw, want := range tests {
+		_, err := ParseLimit(raw)
+		assert.True(t, errors.Is(err, want), raw)
+	}
+}
+
+func TestLimitTextRoundTrip(t *testing.T) {
+	l := MustParseLimit("10/1m/g")
+	text, err := l.MarshalText()
+	require.NoError(t, err)
+
+	var res Limit
+	require.NoError(t, res.UnmarshalText(text))
+	assert.Equal(t, l, res)
+}
+
+func TestLimitUnmarshalTextReportsInvalidLimit(t *testing.T) {
+	var l Limit
+	err := l.UnmarshalText([]byte("1/1x"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid limit "1/1x"`)
+}
+
+func TestLimitsJSONRoundTrip(t *testing.T) {
+	type config struct {
+		Limit  Limit  `json:"limit"`
+		Limits Limits `json:"limits"`
+	}
+	in := config{
+		Limit:  MustParseLimit("1/1s"),
+		Limits: Limits{MustParseLimit("10/1m"), MustParseLimit("100/1h/g")},
+	}
+
+	b, err := json.Marshal(in)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"limit":"1/1s","limits":["10/1m0s","100/1h0m0s/g"]}`, string(b))
+
+	var out config
+	require.NoError(t, json.Unmarshal(b, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestLimitsUnmarshalJSONRejectsNonStrings(t *testing.T) {
+	var ls Limits
+	assert.Error(t, json.Unmarshal([]byte(`[10]`), &ls))
+	assert.Error(t, json.Unmarshal([]byte(`["10/1x"]`), &ls))
+}
+
+func TestLimitsYAMLRoundTrip(t *testing.T) {
+	type config struct {
+		Limit  Limit  `yaml:"limit"`
+		Limits Limits `yaml:"limits"`
+	}
+	in := config{
+		Limit:  MustParseLimit("1/1s"),
+		Limits: Limits{MustParseLimit("10/1m"), MustParseLimit("100/1h/g")},
+	}
+
+	b, err := yaml.Marshal(in)
+	require.NoError(t, err)
+
+	var out config
+	require.NoError(t, yaml.Unmarshal(b, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestLimitsUnmarshalYAMLAcceptsCommaSeparatedString(t *testing.T) {
+	var ls Limits
+	require.NoError(t, yaml.Unmarshal([]byte(`10/1m, 100/1h/g`), &ls))
+	assert.Equal(t, Limits{MustParseLimit("10/1m"), MustParseLimit("100/1h/g")}, ls)
+}
+
+func TestLimitsUnmarshalYAMLReportsLine(t *testing.T) {
+	var ls Limits
+	err := yaml.Unmarshal([]byte("- 10/1m\n- 10/1x\n"), &ls)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `line 2: invalid limit "10/1x"`)
+}
+
+func TestLimitsFlag(t *testing.T) {
+	var limit Limit
+	var limits Limits
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&limit, "limit", "")
+	fs.Var(&limits, "limits", "")
+
+	require.NoError(t, fs.Parse([]string{"-limit", "5/1s", "-limits", "10/1m,100/1h", "-limits", "1000/24h/g"}))
+
+	assert.Equal(t, MustParseLimit("5/1s"), limit)
+	assert.Equal(t, Limits{
+		MustParseLimit("10/1m"),
+		MustParseLimit("100/1h"),
+		{Global: true, Limit: 1000, Dur: 24 * time.Hour},
+	}, limits)
+	assert.Equal(t, "10/1m0s,100/1h0m0s,1000/24h0m0s/g", limits.String())
+}
@@ -1,21 +1,399 @@
 package ratelimiter
 
 import (
+	"context"
 	"net/http"
 	"strings"
 )
 
-// Middleware creates a new rate limiter for HTTP
-func Middleware(l Limiter) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		fn := func(w http.ResponseWriter, r *http.Request) {
-			if l.Limit(strings.Split(r.RemoteAddr, ":")[0]) {
-				w.WriteHeader(http.StatusTooManyRequests)
-				return
+// MiddlewareOption configures optional behaviour of Middleware
+type MiddlewareOption func(*middleware)
+
+type middleware struct {
+	limiter  Limiter
+	key      KeyFunc
+	refund   func(status int) bool
+	count    func(status int) bool
+	reset    func(status int) bool
+	observer Observer
+	tracer   Tracer
+	secret   []byte
+	inFlight ConcurrencyLimiter
+	queue    *Queue
+	priority PriorityFunc
+}
+
+// RefundIf makes Middleware give back the units consumed by a request when fn
+// returns true for the status code written by the handler. The limiter must
+// implement Reserver, otherwise Middleware panics
+func RefundIf(fn func(status int) bool) MiddlewareOption {
+	return func(m *middleware) {
+		m.refund = fn
+	}
+}
+
+// CountIf makes Middleware check the limiter before calling the handler
+// without consuming any units. A unit is only consumed when fn returns true
+// for the status code written by the handler, which is useful to only count
+// failed logins. The limiter must implement Counter. The check and the
+// consume aren't atomic, see Counter
+func CountIf(fn func(status int) bool) MiddlewareOption {
+	return func(m *middleware) {
+		m.count = fn
+	}
+}
+
+// ResetIf makes Middleware reset the counters of a key when fn returns true
+// for the status code written by the handler, for example after a successful
+// login. It is used together with CountIf
+func ResetIf(fn func(status int) bool) MiddlewareOption {
+	return func(m *middleware) {
+		m.reset = fn
+	}
+}
+
+// WithObserver makes Middleware send an EventAllow or EventDeny to o for every
+// request and an EventReset whenever ResetIf resets a key
+func WithObserver(o Observer) MiddlewareOption {
+	return func(m *middleware) {
+		m.observer = o
+	}
+}
+
+// WithTracer makes Middleware record a span with t around every limit check.
+// The span is a child of the span in the request context and is passed on to
+// limiters which implement ContextLimiter
+func WithTracer(t Tracer) MiddlewareOption {
+	return func(m *middleware) {
+		m.tracer = t
+	}
+}
+
+// WithTraceKeySecret makes Middleware attach the HMAC of the key with secret
+// to its spans. Keys are left out of spans without it
+func WithTraceKeySecret(secret []byte) MiddlewareOption {
+	return func(m *middleware) {
+		m.secret = secret
+	}
+}
+
+// WithKeyFunc makes Middleware limit requests by the key returned by fn
+// instead of the IP address of the client
+func WithKeyFunc(fn KeyFunc) MiddlewareOption {
+	return func(m *middleware) {
+		m.key = fn
+	}
+}
+
+// WithConcurrency makes Middleware take a slot from c for every request before
+// checking the limiter and give it back when the handler returns. Requests
+// without a free slot are rejected with http.StatusTooManyRequests
+func WithConcurrency(c ConcurrencyLimiter) MiddlewareOption {
+	return func(m *middleware) {
+		m.inFlight = c
+	}
+}
+
+// WithQueue makes Middleware hold requests back in q instead of rejecting
+// bursts. Requests are only rejected with http.StatusTooManyRequests when q is
+// full or the delay would be too long. Requests of clients which disconnect
+// while they wait are dropped without a response. Requests which leave q are
+// still checked by the limiter
+func WithQueue(q *Queue) MiddlewareOption {
+	return func(m *middleware) {
+		m.queue = q
+	}
+}
+
+// WithPriority makes Middleware check requests with the class returned by fn.
+// It only has an effect when the limiter implements PrioritizedLimiter
+func WithPriority(fn PriorityFunc) MiddlewareOption {
+	return func(m *middleware) {
+		m.priority = fn
+	}
+}
+
+// KeyFunc returns the key a request is limited by
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP limits requests by the IP address in r.RemoteAddr. It is the
+// default KeyFunc of Middleware
+func KeyByIP(r *http.Request) string {
+	return strings.Split(r.RemoteAddr, ":")[0]
+}
+
+// KeyByHeader limits requests by the value of the header name, such as an API
+// key. Requests without the header are limited by their IP address
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+		return KeyByIP(r)
+	}
+}
+
+// StatusIn returns a function which reports whether a status code is one of
+// codes. It is meant to be used with RefundIf, CountIf and ResetIf
+func StatusIn(codes ...int) func(status int) bool {
+	return func(status int) bool {
+		for _, code := range codes {
+			if status == code {
+				return true
 			}
+		}
+		return false
+	}
+}
+
+// Middleware creates a new rate limiter for HTTP. It panics when RefundIf is
+// used with a limiter which doesn't implement Reserver or CountIf with a
+// limiter which doesn't implement Counter
+func Middleware(l Limiter, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	m := &middleware{limiter: l, key: KeyByIP}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if _, ok := l.(Reserver); m.refund != nil && !ok {
+		panic("ratelimiter: RefundIf requires a limiter which implements Reserver")
+	}
+	if _, ok := l.(Counter); m.count != nil && !ok {
+		panic("ratelimiter: CountIf requires a limiter which implements Counter")
+	}
 
-			next.ServeHTTP(w, r)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			m.serve(next, w, r)
 		}
 		return http.HandlerFunc(fn)
 	}
 }
+
+func (m *middleware) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	ip := m.key(r)
+
+	if m.queue != nil {
+		switch err := m.queue.Wait(r.Context(), ip); err {
+		case nil:
+		case ErrQueueFull, ErrDelayTooLong:
+			m.deny(w, ip)
+			return
+		default:
+			// the client is gone
+			return
+		}
+	}
+
+	if m.inFlight != nil {
+		release, ok := m.inFlight.Acquire(ip)
+		if !ok {
+			m.deny(w, ip)
+			return
+		}
+		defer release()
+	}
+
+	if c, ok := m.limiter.(Counter); ok && m.count != nil {
+		m.serveCounted(c, ip, next, w, r)
+		return
+	}
+	if rs, ok := m.limiter.(Reserver); ok && m.refund != nil {
+		m.serveReserved(rs, ip, next, w, r)
+		return
+	}
+
+	if m.limit(r, ip) {
+		m.deny(w, ip)
+		return
+	}
+
+	m.allow(ip)
+	m.handle(ip, next, w, r)
+}
+
+// handle calls the handler and returns the status code it wrote. When the
+// limiter is a Recorder the status code and the latency of the handler, as
+// measured by the Clock of the limiter, are recorded. Time spent in the queue
+// of WithQueue isn't part of the latency
+func (m *middleware) handle(ip string, next http.Handler, w http.ResponseWriter, r *http.Request) int {
+	sw := &statusWriter{ResponseWriter: w}
+	rec, ok := m.limiter.(Recorder)
+	if !ok {
+		next.ServeHTTP(sw.wrap(), r)
+		return sw.Status()
+	}
+
+	c := SystemClock
+	if cl, ok := rec.(clocked); ok {
+		c = cl.clock()
+	}
+	start := c.Now()
+	next.ServeHTTP(sw.wrap(), r)
+	rec.Record(ip, sw.Status(), c.Now().Sub(start))
+	return sw.Status()
+}
+
+// limit checks ip with the limiter inside a span
+func (m *middleware) limit(r *http.Request, ip string) bool {
+	return m.trace(r, ip, func(ctx context.Context) bool {
+		if pl, ok := m.limiter.(PrioritizedLimiter); ok && m.priority != nil {
+			return pl.LimitPriority(ip, m.priority(r))
+		}
+		if cl, ok := m.limiter.(ContextLimiter); ok {
+			return cl.LimitContext(ctx, ip)
+		}
+		return m.limiter.Limit(ip)
+	})
+}
+
+// trace runs check inside a span and records its decision
+func (m *middleware) trace(r *http.Request, ip string, check func(ctx context.Context) bool) bool {
+	ctx, span := startSpan(r.Context(), m.tracer, "ratelimiter.middleware")
+	defer span.End()
+
+	limited := check(ctx)
+	if m.tracer != nil {
+		decision := DecisionAllowed
+		if limited {
+			decision = DecisionDenied
+		}
+		span.SetAttributes(append(keyAttributes(m.secret, ip),
+			Attribute{Key: AttributeDecision, Value: string(decision)},
+		)...)
+	}
+	return limited
+}
+
+func (m *middleware) serveReserved(l Reserver, ip string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	var res *Reservation
+	limited := m.trace(r, ip, func(context.Context) bool {
+		res = l.Reserve(ip)
+		return res.Limited
+	})
+	if limited {
+		m.deny(w, ip)
+		return
+	}
+	m.allow(ip)
+
+	if m.refund(m.handle(ip, next, w, r)) {
+		// errors are reported by the limiter
+		_ = res.Cancel()
+	}
+}
+
+func (m *middleware) serveCounted(l Counter, ip string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	limited := m.trace(r, ip, func(context.Context) bool {
+		return l.Peek(ip)
+	})
+	if limited {
+		m.deny(w, ip)
+		return
+	}
+	m.allow(ip)
+
+	status := m.handle(ip, next, w, r)
+	if m.count(status) {
+		l.Consume(ip)
+		return
+	}
+	if m.reset != nil && m.reset(status) {
+		// the response has already been written and a failed reset only
+		// means the key keeps the units it consumed
+		if l.Reset(ip) == nil {
+			observe(m.observer, Event{Type: EventReset, Key: ip})
+		}
+	}
+}
+
+func (m *middleware) allow(ip string) {
+	observe(m.observer, Event{Type: EventAllow, Key: ip})
+}
+
+func (m *middleware) deny(w http.ResponseWriter, ip string) {
+	observe(m.observer, Event{Type: EventDeny, Key: ip})
+	w.WriteHeader(http.StatusTooManyRequests)
+}
+
+// statusWriter records the status code written to an http.ResponseWriter.
+// Handlers are given the result of wrap so that they keep access to the
+// optional interfaces of the original writer
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Status returns the status code written by the handler. Handlers which don't
+// write anything respond with http.StatusOK
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// wrap returns w with the http.Flusher, http.Hijacker and http.Pusher of the
+// original writer, so handlers can still stream and upgrade connections
+func (w *statusWriter) wrap() http.ResponseWriter {
+	f, flusher := w.ResponseWriter.(http.Flusher)
+	h, hijacker := w.ResponseWriter.(http.Hijacker)
+	p, pusher := w.ResponseWriter.(http.Pusher)
+
+	switch {
+	case flusher && hijacker && pusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, f, h, p}
+	case flusher && hijacker:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{w, f, h}
+	case flusher && pusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+		}{w, f, p}
+	case hijacker && pusher:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{w, h, p}
+	case flusher:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+		}{w, f}
+	case hijacker:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{w, h}
+	case pusher:
+		return struct {
+			http.ResponseWriter
+			http.Pusher
+		}{w, p}
+	}
+	return w
+}
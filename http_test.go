@@ -1,11 +1,16 @@
 package ratelimiter
 
 import (
+	"bufio"
+	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -72,6 +77,116 @@ func TestMiddlewareSetsStatusCodeToTooManyRequestsWhenLimiterReturnsTrue(t *test
 	require.EqualValues(t, http.StatusTooManyRequests, w.Code)
 }
 
+func TestMiddlewareRefundIfGivesBackUnitsWhenStatusMatches(t *testing.T) {
+	limiter := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}})
+	next := &fakeHandler{
+		ServeHTTPFunc: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		},
+	}
+
+	mw := Middleware(limiter, RefundIf(func(status int) bool {
+		return status == http.StatusBadRequest
+	}))
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "127.0.0.1:22826"
+
+		mw(next).ServeHTTP(w, r)
+		require.EqualValues(t, http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestMiddlewareRefundIfKeepsUnitsWhenStatusDoesNotMatch(t *testing.T) {
+	limiter := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}})
+	next := &fakeHandler{}
+
+	mw := Middleware(limiter, RefundIf(func(status int) bool {
+		return status == http.StatusBadRequest
+	}))
+
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "127.0.0.1:22826"
+
+		mw(next).ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+	}
+	require.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, codes)
+}
+
+func TestMiddlewareCountIfOnlyCountsMatchingStatus(t *testing.T) {
+	limiter := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 2}})
+	status := http.StatusOK
+	next := &fakeHandler{
+		ServeHTTPFunc: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		},
+	}
+
+	mw := Middleware(limiter, CountIf(StatusIn(http.StatusUnauthorized, http.StatusForbidden)))
+	serve := func() int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/login", nil)
+		r.RemoteAddr = "127.0.0.1:22826"
+
+		mw(next).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < 5; i++ {
+		require.EqualValues(t, http.StatusOK, serve())
+	}
+
+	status = http.StatusUnauthorized
+	require.EqualValues(t, http.StatusUnauthorized, serve())
+	require.EqualValues(t, http.StatusUnauthorized, serve())
+	require.EqualValues(t, http.StatusTooManyRequests, serve())
+}
+
+func TestMiddlewareResetIfClearsFailures(t *testing.T) {
+	limiter := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 2}})
+	status := http.StatusUnauthorized
+	next := &fakeHandler{
+		ServeHTTPFunc: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		},
+	}
+
+	mw := Middleware(limiter,
+		CountIf(StatusIn(http.StatusUnauthorized)),
+		ResetIf(StatusIn(http.StatusOK)),
+	)
+	serve := func() int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/login", nil)
+		r.RemoteAddr = "127.0.0.1:22826"
+
+		mw(next).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	serve()
+	status = http.StatusOK
+	serve()
+
+	status = http.StatusUnauthorized
+	require.EqualValues(t, http.StatusUnauthorized, serve())
+	require.EqualValues(t, http.StatusUnauthorized, serve())
+	require.EqualValues(t, http.StatusTooManyRequests, serve())
+}
+
+func TestStatusIn(t *testing.T) {
+	fn := StatusIn(http.StatusUnauthorized, http.StatusForbidden)
+	require.True(t, fn(http.StatusUnauthorized))
+	require.True(t, fn(http.StatusForbidden))
+	require.False(t, fn(http.StatusOK))
+}
+
 type fakeHandler struct {
 	ServeHTTPFunc func(http.ResponseWriter, *http.Request)
 }
@@ -93,3 +208,239 @@ func (f *fakeLimiter) Limit(ip string) bool {
 	}
 	return false
 }
+
+func TestMiddlewareLimitsByKeyFunc(t *testing.T) {
+	var key string
+	limiter := &fakeLimiter{
+		LimitFunc: func(k string) bool {
+			key = k
+			return false
+		},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", "abc")
+	Middleware(limiter, WithKeyFunc(KeyByHeader("X-API-Key")))(&fakeHandler{}).ServeHTTP(w, r)
+
+	assert.Equal(t, "abc", key)
+}
+
+func TestMiddlewareWithConcurrencyReleasesWhenHandlerReturns(t *testing.T) {
+	c := NewMemoryConcurrencyLimiter(1, 0)
+	inside := make(chan struct{})
+	proceed := make(chan struct{})
+	next := &fakeHandler{
+		ServeHTTPFunc: func(w http.ResponseWriter, r *http.Request) {
+			close(inside)
+			<-proceed
+		},
+	}
+	h := Middleware(&fakeLimiter{}, WithConcurrency(c))(next)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		h.ServeHTTP(w, r)
+	}()
+	<-inside
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	Middleware(&fakeLimiter{}, WithConcurrency(c))(&fakeHandler{}).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	close(proceed)
+	<-done
+	assert.Equal(t, 0, c.InFlight("192.0.2.1"))
+}
+
+func TestMiddlewareWithConcurrencyReleasesWhenRateLimited(t *testing.T) {
+	c := NewMemoryConcurrencyLimiter(1, 0)
+	limiter := &fakeLimiter{
+		LimitFunc: func(string) bool {
+			return true
+		},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	Middleware(limiter, WithConcurrency(c))(&fakeHandler{}).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, 0, c.InFlight("192.0.2.1"))
+}
+
+func TestMiddlewareWithQueueRejectsWhenDelayIsTooLong(t *testing.T) {
+	now := time.Now()
+	q := NewQueue(Limit{Dur: time.Second, Limit: 1}, 100*time.Millisecond)
+	q.Clock = funcClock(func() time.Time { return now })
+	h := Middleware(&fakeLimiter{}, WithQueue(q))(&fakeHandler{})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestMiddlewareWithQueueDropsDisconnectedClients(t *testing.T) {
+	now := time.Now()
+	q := NewQueue(Limit{Dur: time.Hour, Limit: 1}, time.Hour)
+	q.Clock = funcClock(func() time.Time { return now })
+	called := 0
+	next := &fakeHandler{
+		ServeHTTPFunc: func(w http.ResponseWriter, r *http.Request) {
+			called++
+		},
+	}
+	h := Middleware(&fakeLimiter{}, WithQueue(q))(next)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+	assert.Equal(t, 1, called)
+	assert.NotEqual(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, 0, q.Depth("192.0.2.1"))
+}
+
+func TestMiddlewareRecordsRequestsWithRecorder(t *testing.T) {
+	now := time.Now()
+	a := NewAdaptiveLimiter([]Limit{{Dur: time.Minute, Limit: 10}})
+	a.Clock = funcClock(func() time.Time { return now })
+	next := &fakeHandler{
+		ServeHTTPFunc: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	}
+	h := Middleware(a)(next)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	now = now.Add(time.Second)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, 5, a.Effective()[0].Limit)
+}
+
+func TestMiddlewareWithPriorityShedsLowPriority(t *testing.T) {
+	l := NewPriorityLimiter(nil, []Limit{{Dur: time.Minute, Limit: 1}}, map[Priority]float64{
+		PriorityCritical: 1,
+	})
+	h := Middleware(l, WithPriority(PriorityByKey(KeyByHeader("X-API-Key"), func(key string) Priority {
+		if key == "health" {
+			return PriorityCritical
+		}
+		return PriorityLow
+	})))(&fakeHandler{})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", "health")
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMiddlewareRefundIfRequiresReserver(t *testing.T) {
+	assert.Panics(t, func() {
+		Middleware(&fakeLimiter{}, RefundIf(StatusIn(http.StatusBadRequest)))
+	})
+}
+
+func TestMiddlewareCountIfRequiresCounter(t *testing.T) {
+	assert.Panics(t, func() {
+		Middleware(&fakeLimiter{}, CountIf(StatusIn(http.StatusUnauthorized)))
+	})
+}
+
+func TestMiddlewareKeepsOptionalInterfacesOfResponseWriter(t *testing.T) {
+	limiter := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 10}})
+
+	var flusher, hijacker bool
+	next := &fakeHandler{
+		ServeHTTPFunc: func(w http.ResponseWriter, r *http.Request) {
+			_, flusher = w.(http.Flusher)
+			_, hijacker = w.(http.Hijacker)
+			w.WriteHeader(http.StatusBadRequest)
+		},
+	}
+
+	mw := Middleware(limiter, RefundIf(StatusIn(http.StatusBadRequest)))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	mw(next).ServeHTTP(w, r)
+	assert.True(t, flusher)
+	assert.False(t, hijacker, "httptest.ResponseRecorder can't be hijacked")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	hw := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+	mw(next).ServeHTTP(hw, r)
+	assert.True(t, flusher)
+	assert.True(t, hijacker)
+}
+
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+func TestMiddlewareRecordsOnEveryPath(t *testing.T) {
+	now := time.Now()
+	rec := &fakeRecorder{
+		MemoryLimiter: NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 10}}),
+		c:             funcClock(func() time.Time { return now }),
+	}
+	next := &fakeHandler{
+		ServeHTTPFunc: func(w http.ResponseWriter, r *http.Request) {
+			now = now.Add(time.Second)
+			w.WriteHeader(http.StatusBadRequest)
+		},
+	}
+
+	for _, opt := range []MiddlewareOption{
+		WithKeyFunc(KeyByIP),
+		RefundIf(StatusIn(http.StatusBadRequest)),
+		CountIf(StatusIn(http.StatusBadRequest)),
+		WithQueue(NewQueue(Limit{Dur: time.Second, Limit: 100}, time.Second)),
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		Middleware(rec, opt)(next).ServeHTTP(w, r)
+	}
+
+	assert.Equal(t, []int{400, 400, 400, 400}, rec.statuses)
+	assert.Equal(t, []time.Duration{time.Second, time.Second, time.Second, time.Second}, rec.latencies)
+}
+
+// fakeRecorder is a Recorder which remembers what was recorded and measures
+// time with c
+type fakeRecorder struct {
+	*MemoryLimiter
+	c         Clock
+	statuses  []int
+	latencies []time.Duration
+}
+
+func (f *fakeRecorder) Record(key string, status int, latency time.Duration) {
+	f.statuses = append(f.statuses, status)
+	f.latencies = append(f.latencies, latency)
+}
+
+func (f *fakeRecorder) clock() Clock {
+	return f.c
+}
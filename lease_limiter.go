@@ -0,0 +1,255 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// acquireLease takes a whole chunk and returns the count after taking it. The
+// caller gives back what went over the limit, which keeps arithmetic out of
+// the script
+var acquireLease = redis.NewScript(1, `
+local used = redis.call("INCRBY", KEYS[1], ARGV[1])
+if redis.call("PTTL", KEYS[1]) < 0 then
+    redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return {used, redis.call("PTTL", KEYS[1])}`)
+
+var releaseLease = redis.NewScript(1, `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+    redis.call("DECRBY", KEYS[1], ARGV[1])
+end
+return 0`)
+
+// NewLeaseLimiter creates a properly initialized LeaseLimiter which leases 5%
+// of a limit at a time for up to 10 seconds
+func NewLeaseLimiter(pool redisPool, limits []Limit) *LeaseLimiter {
+	// limits must be sorted by TTL descending so that smaller limits don't
+	// short circuit the longer ones
+	sort.Sort(byDuration(limits))
+
+	return &LeaseLimiter{
+		Pool:          pool,
+		Limits:        limits,
+		ChunkSize:     DefaultChunkSize,
+		LeaseDuration: 10 * time.Second,
+		leases:        make(map[string]*lease),
+		Clock:         SystemClock,
+	}
+}
+
+// DefaultChunkSize leases 5% of l.Limit at a time
+func DefaultChunkSize(l Limit) int {
+	if l.Limit < 20 {
+		return 1
+	}
+	return l.Limit / 20
+}
+
+// LeaseLimiter is a rate limit which leases chunks of a limit from Redis and
+// serves requests from the local chunk until it is used up or the lease
+// expires. Leasing takes a single round trip per ChunkSize requests, which
+// takes the load off hot keys such as the one shared by Global limits.
+//
+// Unused tokens are given back when a lease expires after LeaseDuration so
+// that other instances can lease them. Instances never allow more than the
+// limit in total, however tokens leased by an idle instance are unavailable to
+// the others until its lease expires
+type LeaseLimiter struct {
+	Pool          redisPool
+	Limits        []Limit
+	ChunkSize     func(l Limit) int
+	LeaseDuration time.Duration
+	OnError       func(ip string, err error)
+	Observer      Observer
+	Clock         Clock
+
+	mu        sync.Mutex
+	leases    map[string]*lease
+	nextSweep time.Time
+}
+
+// lease holds the tokens an instance leased from a counter in Redis
+type lease struct {
+	ip      string
+	key     string
+	limit   Limit
+	tokens  int
+	expires time.Time
+	reset   time.Time
+	// dry is set when the last attempt to lease got no tokens so that the
+	// lease isn't asked for again until it expires
+	dry bool
+}
+
+// Limit checks an IP address to see if it should be ratelimited. It returns
+// true if the IP address should be ratelimited and false otherwise
+func (l *LeaseLimiter) Limit(ip string) bool {
+	limited, _, _ := l.LimitRetry(ip)
+	return limited
+}
+
+// LimitRetry checks an IP address the same way as Limit but also returns how
+// long the IP address must wait before it will be allowed again. Errors are
+// reported to OnError and limit the IP address until the next attempt to
+// lease so the error is always nil
+func (l *LeaseLimiter) LimitRetry(ip string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Clock.Now()
+	l.sweep(now)
+
+	taken := make([]*lease, 0, len(l.Limits))
+	for _, limit := range l.Limits {
+		le := l.lease(ip, limit, now)
+		if le.tokens == 0 && !le.dry {
+			if err := l.acquire(le, now); err != nil {
+				l.reportError(ip, err)
+			}
+		}
+		if le.tokens == 0 {
+			// the tokens taken from longer limits weren't used
+			for _, t := range taken {
+				t.tokens++
+			}
+			retry := le.expires.Sub(now)
+			if le.reset.After(le.expires) {
+				retry = le.reset.Sub(now)
+			}
+			observe(l.Observer, Event{Type: EventDeny, Key: ip, Limit: limit, RetryAfter: retry})
+			return true, retry, nil
+		}
+		le.tokens--
+		taken = append(taken, le)
+	}
+
+	observe(l.Observer, Event{Type: EventAllow, Key: ip})
+	return false, 0, nil
+}
+
+// reportError sends err to OnError and Observer
+func (l *LeaseLimiter) reportError(ip string, err error) {
+	if l.OnError != nil {
+		l.OnError(ip, err)
+	}
+	observe(l.Observer, Event{Type: EventError, Key: ip, Err: err})
+}
+
+// Release gives back the unused tokens of every lease. It should be called
+// before shutting down so that other instances can lease them
+func (l *LeaseLimiter) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Clock.Now()
+	for key, le := range l.leases {
+		if err := l.release(le, now); err != nil {
+			return err
+		}
+		delete(l.leases, key)
+	}
+	return nil
+}
+
+// lease returns the lease of ip for limit. Expired leases give back their
+// unused tokens first. l.mu must be held
+func (l *LeaseLimiter) lease(ip string, limit Limit, now time.Time) *lease {
+	key := counterKey("leased", ip, limit)
+	le := l.leases[key]
+	if le == nil {
+		le = &lease{ip: ip, key: key, limit: limit}
+		l.leases[key] = le
+	}
+
+	if !now.Before(le.expires) {
+		if err := l.release(le, now); err != nil {
+			l.reportError(ip, err)
+		}
+		le.dry = false
+	}
+	return le
+}
+
+// acquire leases a chunk of tokens from Redis. When no tokens are left the
+// lease stays empty until the window resets or another instance may have
+// given back tokens. l.mu must be held
+func (l *LeaseLimiter) acquire(le *lease, now time.Time) error {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	// don't ask again until the lease expires, even if this attempt fails
+	le.expires = now.Add(l.LeaseDuration)
+	le.dry = true
+
+	chunk := l.ChunkSize(le.limit)
+	window := le.limit.Dur.Nanoseconds() / int64(time.Millisecond)
+	res, err := redis.Ints(acquireLease.Do(con, le.key, chunk, window))
+	if err != nil {
+		return fmt.Errorf("%s: %s", "failed to acquire lease", err)
+	}
+
+	grant := chunk
+	if over := res[0] - le.limit.Limit; over > 0 {
+		if over > chunk {
+			over = chunk
+		}
+		grant -= over
+		// other instances may briefly see the count above the limit, which
+		// only denies them early
+		if _, err := releaseLease.Do(con, le.key, over); err != nil {
+			return fmt.Errorf("%s: %s", "failed to give back overshoot", err)
+		}
+	}
+
+	le.tokens = grant
+	le.dry = grant == 0
+	if res[1] > 0 {
+		le.reset = now.Add(time.Duration(res[1]) * time.Millisecond)
+		if le.reset.Before(le.expires) {
+			le.expires = le.reset
+		}
+	}
+	return nil
+}
+
+// release gives back the unused tokens of le as long as the window they were
+// leased from hasn't ended. l.mu must be held
+func (l *LeaseLimiter) release(le *lease, now time.Time) error {
+	tokens := le.tokens
+	le.tokens = 0
+	if tokens == 0 || !now.Before(le.reset) {
+		return nil
+	}
+
+	con := l.Pool.Get()
+	defer con.Close()
+
+	if _, err := releaseLease.Do(con, le.key, tokens); err != nil {
+		return fmt.Errorf("%s: %s", "failed to release lease", err)
+	}
+	return nil
+}
+
+// sweep gives back the unused tokens of expired leases and removes them so
+// that IP addresses which are never seen again don't hold on to tokens. l.mu
+// must be held
+func (l *LeaseLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	for key, le := range l.leases {
+		if now.Before(le.expires) {
+			continue
+		}
+		if err := l.release(le, now); err != nil {
+			l.reportError(le.ip, err)
+		}
+		delete(l.leases, key)
+	}
+	l.nextSweep = now.Add(sweepInterval)
+}
@@ -0,0 +1,197 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseLimiterNeverExceedsLimit(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := Limit{Dur: time.Minute, Limit: 1000, Global: true}
+	limiters := make([]*LeaseLimiter, 3)
+	for i := range limiters {
+		limiters[i] = NewLeaseLimiter(&fakePool{addr: srv.Addr()}, []Limit{l})
+	}
+
+	allowed := 0
+	for i := 0; i < 2000; i++ {
+		if !limiters[i%len(limiters)].Limit("a") {
+			allowed++
+		}
+	}
+
+	assert.Equal(t, l.Limit, allowed)
+}
+
+func TestLeaseLimiterLeasesChunks(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := NewLeaseLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1000, Global: true}})
+
+	assert.False(t, l.Limit("a"))
+	commands := srv.CommandCount()
+	for i := 0; i < 49; i++ {
+		assert.False(t, l.Limit("b"))
+	}
+	assert.Equal(t, commands, srv.CommandCount())
+
+	used, err := srv.Get("leased:global:60")
+	require.NoError(t, err)
+	assert.Equal(t, "50", used)
+}
+
+func TestLeaseLimiterReturnsUnusedTokensWhenLeaseExpires(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	now := time.Now()
+	l := NewLeaseLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1000, Global: true}})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	for i := 0; i < 10; i++ {
+		l.Limit("a")
+	}
+
+	now = now.Add(l.LeaseDuration)
+	l.Limit("a")
+
+	used, err := srv.Get("leased:global:60")
+	require.NoError(t, err)
+	assert.Equal(t, "60", used)
+}
+
+func TestLeaseLimiterLimitsUntilLeaseExpires(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	now := time.Now()
+	l := NewLeaseLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 2}})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	assert.False(t, l.Limit("a"))
+	assert.False(t, l.Limit("a"))
+
+	limited, retry, err := l.LimitRetry("a")
+	require.NoError(t, err)
+	assert.True(t, limited)
+	assert.Equal(t, time.Minute, retry)
+
+	commands := srv.CommandCount()
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.Limit("a"))
+	}
+	assert.Equal(t, commands, srv.CommandCount())
+
+	now = now.Add(time.Minute)
+	srv.FastForward(time.Minute)
+	assert.False(t, l.Limit("a"))
+}
+
+func TestLeaseLimiterGivesBackTokensOfLongerLimits(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	now := time.Now()
+	l := NewLeaseLimiter(&fakePool{addr: srv.Addr()}, []Limit{
+		{Dur: time.Second, Limit: 1},
+		{Dur: time.Hour, Limit: 2},
+	})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	assert.False(t, l.Limit("a"))
+	assert.True(t, l.Limit("a"))
+	assert.True(t, l.Limit("a"))
+
+	now = now.Add(time.Second)
+	srv.FastForward(time.Second)
+	assert.False(t, l.Limit("a"))
+}
+
+func TestLeaseLimiterRelease(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := NewLeaseLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1000, Global: true}})
+	l.Limit("a")
+
+	require.NoError(t, l.Release())
+	used, err := srv.Get("leased:global:60")
+	require.NoError(t, err)
+	assert.Equal(t, "1", used)
+}
+
+func TestLeaseLimiterReportsErrors(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	var result string
+	l := NewLeaseLimiter(&deadPool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 10}})
+	l.OnError = func(ip string, err error) {
+		assert.Error(t, err)
+		result = ip
+	}
+
+	assert.True(t, l.Limit("a"))
+	assert.Equal(t, "a", result)
+}
+
+func TestLeaseLimiterSweepReleasesExpiredLeases(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	now := time.Now()
+	l := NewLeaseLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Hour, Limit: 1000}})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	l.Limit("a")
+	used, err := srv.Get("leased:a:3600")
+	require.NoError(t, err)
+	assert.Equal(t, "50", used)
+
+	now = now.Add(sweepInterval)
+	l.Limit("b")
+
+	used, err = srv.Get("leased:a:3600")
+	require.NoError(t, err)
+	assert.Equal(t, "1", used)
+	assert.Len(t, l.leases, 1)
+}
+
+func TestLeaseLimiterGivesBackOvershoot(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	a := NewLeaseLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 30}})
+	b := NewLeaseLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 30}})
+	a.ChunkSize = func(Limit) int { return 20 }
+	b.ChunkSize = a.ChunkSize
+
+	a.Limit("x")
+	b.Limit("x")
+
+	used, err := srv.Get("leased:x:60")
+	require.NoError(t, err)
+	assert.Equal(t, "30", used)
+	assert.Equal(t, 9, b.leases["leased:x:60"].tokens)
+}
+
+func TestDefaultChunkSize(t *testing.T) {
+	assert.Equal(t, 1, DefaultChunkSize(Limit{Limit: 10}))
+	assert.Equal(t, 50, DefaultChunkSize(Limit{Limit: 1000}))
+}
@@ -2,6 +2,8 @@ package ratelimiter
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"time"
 )
 
@@ -10,22 +12,66 @@ type Limiter interface {
 	Limit(ip string) bool
 }
 
+// Counter is a Limiter which can check a key without consuming any units and
+// consume units separately. It is used to only count some requests, for
+// example failed logins.
+//
+// Peek followed by Consume isn't atomic. Concurrent requests of a key can all
+// pass Peek before any of them is consumed, so a key may go over its limit by
+// the number of its requests in flight
+type Counter interface {
+	Limiter
+	// Peek reports if key would be limited without consuming a unit
+	Peek(key string) bool
+	// Consume takes a unit for key from every limit
+	Consume(key string)
+	// Reset clears the counters of key
+	Reset(key string) error
+}
+
 // Limit is a limiter used with New to execuate a ratelimiter
 type Limit struct {
 	Global bool
 	Limit  int
 	Dur    time.Duration
+	// Name is an optional label. Named limits have their own counters so
+	// login:5/1m and api:100/1m don't share one
+	Name string
+	// Network counts keys which are IP addresses per network, /24 for IPv4
+	// and /64 for IPv6, instead of per address. Other keys are counted as is
+	Network bool
 }
 
-func (l *Limit) String() string {
+// String formats l in the format of ParseLimit
+func (l Limit) String() string {
 	s := fmt.Sprintf("%d/%s", l.Limit, l.Dur)
+	if l.Name != "" {
+		s = l.Name + ":" + s
+	}
 	if l.Global {
 		return s + "/g"
 	}
+	if l.Network {
+		return s + "/net"
+	}
 
 	return s
 }
 
+// ScaleLimits divides every limit between instances so that a limiter in
+// each instance allows roughly the same amount of requests as a single shared
+// limiter. Limits never drop below one
+func ScaleLimits(limits []Limit, instances int) []Limit {
+	scaled := make([]Limit, len(limits))
+	for i, l := range limits {
+		if instances > 1 {
+			l.Limit = (l.Limit + instances - 1) / instances
+		}
+		scaled[i] = l
+	}
+	return scaled
+}
+
 type byDuration []Limit
 
 func (d byDuration) Len() int      { return len(d) }
@@ -39,3 +85,38 @@ func (d byDuration) Less(i, j int) bool {
 
 	return a.Dur > b.Dur
 }
+
+// limitKey returns the key which holds the counter of ip for l. Global limits
+// share a single key for every ip
+func limitKey(ip string, l Limit) string {
+	return counterKey("requests", ip, l)
+}
+
+// counterKey returns the key in namespace which holds the counter of ip for l.
+// The name of a named limit is appended to namespace after an '@', which can't
+// appear in namespaces, so named counters never collide with unnamed ones
+func counterKey(namespace, ip string, l Limit) string {
+	switch {
+	case l.Global:
+		ip = "global"
+	case l.Network:
+		ip = networkOf(ip)
+	}
+	if l.Name != "" {
+		namespace += "@" + l.Name
+	}
+	return namespace + ":" + ip + ":" + strconv.FormatFloat(l.Dur.Seconds(), 'g', -1, 64)
+}
+
+// networkOf returns the network of key for limits with Network set. Keys which
+// aren't IP addresses are returned as is
+func networkOf(key string) string {
+	ip := net.ParseIP(key)
+	if ip == nil {
+		return key
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
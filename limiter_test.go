@@ -69,3 +69,38 @@ func TestByDurationSortsGlobalBeforeNonGlobal(t *testing.T) {
 	sort.Sort(byDuration(unsorted))
 	assert.Equal(t, sorted, unsorted)
 }
+
+func TestScaleLimitsDividesLimitsBetweenInstances(t *testing.T) {
+	limits := []Limit{
+		{Dur: time.Minute, Limit: 10},
+		{Dur: time.Hour, Limit: 1000, Global: true},
+		{Dur: time.Second, Limit: 1},
+	}
+
+	assert.Equal(t, []Limit{
+		{Dur: time.Minute, Limit: 4},
+		{Dur: time.Hour, Limit: 334, Global: true},
+		{Dur: time.Second, Limit: 1},
+	}, ScaleLimits(limits, 3))
+	assert.Equal(t, limits, ScaleLimits(limits, 0))
+}
+
+func TestLimitKeySeparatesNamedLimits(t *testing.T) {
+	login := Limit{Dur: time.Minute, Limit: 5, Name: "login"}
+	api := Limit{Dur: time.Minute, Limit: 100, Name: "api"}
+
+	assert.Equal(t, "requests:a:60", limitKey("a", Limit{Dur: time.Minute, Limit: 5}))
+	assert.Equal(t, "requests@login:a:60", limitKey("a", login))
+	assert.NotEqual(t, limitKey("a", login), limitKey("a", api))
+	assert.NotEqual(t, limitKey("login:a", Limit{Dur: time.Minute}), limitKey("a", login))
+}
+
+func TestLimitKeyCountsNetworks(t *testing.T) {
+	l := Limit{Dur: time.Minute, Limit: 5, Network: true}
+
+	assert.Equal(t, "requests:10.1.2.0/24:60", limitKey("10.1.2.3", l))
+	assert.Equal(t, limitKey("10.1.2.3", l), limitKey("10.1.2.200", l))
+	assert.NotEqual(t, limitKey("10.1.2.3", l), limitKey("10.1.3.3", l))
+	assert.Equal(t, "requests:2001:db8:1:2::/64:60", limitKey("2001:db8:1:2:3:4:5:6", l))
+	assert.Equal(t, "requests:user-1:60", limitKey("user-1", l), "keys which aren't IP addresses are counted as is")
+}
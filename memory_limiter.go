@@ -0,0 +1,281 @@
+package ratelimiter
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryLimiter removes expired windows
+const sweepInterval = time.Minute
+
+// NewMemoryLimiter creates a properly initialized MemoryLimiter
+func NewMemoryLimiter(limits []Limit) *MemoryLimiter {
+	// limits must be sorted by TTL descending so that smaller limits don't
+	// short circuit the longer ones
+	sort.Sort(byDuration(limits))
+
+	return &MemoryLimiter{
+		Limits:  limits,
+		windows: make(map[string]*window),
+		bans:    make(map[string]time.Time),
+		Clock:   SystemClock,
+	}
+}
+
+// MemoryLimiter is a rate limit which can evaluate an IP address to determine
+// if it should be rate limited using memory as a backend. It uses the same
+// fixed windows as RedisLimiter but counters are local to the process
+type MemoryLimiter struct {
+	Limits    []Limit
+	Collector Collector
+	Observer  Observer
+	Clock     Clock
+
+	mu        sync.Mutex
+	windows   map[string]*window
+	bans      map[string]time.Time
+	nextSweep time.Time
+}
+
+// window counts the units consumed for a key until reset
+type window struct {
+	count int
+	reset time.Time
+}
+
+// Limit checks an IP address to see if it should be ratelimited. It returns
+// true if the IP address should be ratelimited and false otherwise
+func (l *MemoryLimiter) Limit(ip string) bool {
+	limited, _, _ := l.LimitRetry(ip)
+	return limited
+}
+
+// LimitRetry checks an IP address the same way as Limit but also returns how
+// long the IP address must wait before it will be allowed again. The error is
+// always nil
+func (l *MemoryLimiter) LimitRetry(ip string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limited, retry, _ := l.check(ip)
+	return limited, retry, nil
+}
+
+// Reserve checks an IP address the same way as Limit and returns a
+// Reservation which can give back the units that were consumed
+func (l *MemoryLimiter) Reserve(ip string) *Reservation {
+	r, _ := l.reserveRetry(ip)
+	return r
+}
+
+// reserveRetry works like Reserve. The error is always nil
+func (l *MemoryLimiter) reserveRetry(ip string) (*Reservation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limited, retry, consumed := l.check(ip)
+	return &Reservation{
+		Limited:    limited,
+		RetryAfter: retry,
+		cancel: func() error {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			for key, w := range consumed {
+				// only refund the window the unit was taken from
+				if l.windows[key] == w && w.count > 0 {
+					w.count--
+				}
+			}
+			return nil
+		},
+	}, nil
+}
+
+// Peek checks if an IP address would be ratelimited without consuming any
+// units
+func (l *MemoryLimiter) Peek(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Clock.Now()
+	if l.banned(ip, now) > 0 {
+		return true
+	}
+	for _, limit := range l.Limits {
+		w := l.windows[limitKey(ip, limit)]
+		if w != nil && now.Before(w.reset) && w.count >= limit.Limit {
+			return true
+		}
+	}
+	return false
+}
+
+// Consume takes a unit from every limit of an IP address which isn't already
+// exhausted
+func (l *MemoryLimiter) Consume(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Clock.Now()
+	if l.banned(ip, now) > 0 {
+		return
+	}
+	for _, limit := range l.Limits {
+		w := l.window(ip, limit, now)
+		if w.count < limit.Limit {
+			w.count++
+		}
+	}
+}
+
+// Reset clears the counters of an IP address. Global limits are shared by
+// every IP address and are left untouched. The error is always nil
+func (l *MemoryLimiter) Reset(ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, limit := range l.Limits {
+		if !limit.Global {
+			delete(l.windows, limitKey(ip, limit))
+		}
+	}
+	observe(l.Observer, Event{Type: EventReset, Key: ip})
+	return nil
+}
+
+// Status returns the usage of an IP address for every limit. The error is
+// always nil
+func (l *MemoryLimiter) Status(ip string) (Status, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Clock.Now()
+	status := Status{
+		Key:    ip,
+		Banned: l.banned(ip, now),
+		Limits: make([]LimitStatus, 0, len(l.Limits)),
+	}
+	for _, limit := range l.Limits {
+		var count int
+		var reset time.Duration
+		if w := l.windows[limitKey(ip, limit)]; w != nil && now.Before(w.reset) {
+			count, reset = w.count, w.reset.Sub(now)
+		}
+		status.Limits = append(status.Limits, newLimitStatus(limit, count, reset))
+	}
+	return status, nil
+}
+
+// ResetAll clears the counters of every IP address. The error is always nil
+func (l *MemoryLimiter) ResetAll() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.windows = make(map[string]*window)
+	observe(l.Observer, Event{Type: EventReset})
+	return nil
+}
+
+// Ban limits an IP address for dur regardless of its usage. The error is
+// always nil
+func (l *MemoryLimiter) Ban(ip string, dur time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bans[ip] = l.Clock.Now().Add(dur)
+	observe(l.Observer, Event{Type: EventBan, Key: ip, RetryAfter: dur})
+	return nil
+}
+
+// Unban lifts the ban of an IP address. The error is always nil
+func (l *MemoryLimiter) Unban(ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.bans, ip)
+	return nil
+}
+
+// Wait blocks until ip is allowed by l. See Wait for details
+func (l *MemoryLimiter) Wait(ctx context.Context, ip string) error {
+	return wait(ctx, l.Clock, l, ip)
+}
+
+// check consumes a unit for ip from every limit until one of them is
+// exhausted. It returns the windows that a unit was consumed from. l.mu must
+// be held
+func (l *MemoryLimiter) check(ip string) (bool, time.Duration, map[string]*window) {
+	now := l.Clock.Now()
+	l.sweep(now)
+
+	consumed := make(map[string]*window, len(l.Limits))
+	if banned := l.banned(ip, now); banned > 0 {
+		if l.Collector != nil {
+			l.Collector.Decision("", DecisionBanned)
+		}
+		observe(l.Observer, Event{Type: EventDeny, Key: ip, RetryAfter: banned})
+		return true, banned, consumed
+	}
+
+	remaining := -1
+	for _, limit := range l.Limits {
+		w := l.window(ip, limit, now)
+		if w.count >= limit.Limit {
+			retry := w.reset.Sub(now)
+			collectDecision(l.Collector, limit, DecisionDenied)
+			observe(l.Observer, Event{Type: EventDeny, Key: ip, Limit: limit, RetryAfter: retry})
+			return true, retry, consumed
+		}
+		collectDecision(l.Collector, limit, DecisionAllowed)
+		w.count++
+		consumed[limitKey(ip, limit)] = w
+		if left := limit.Limit - w.count; remaining < 0 || left < remaining {
+			remaining = left
+		}
+	}
+
+	observe(l.Observer, Event{Type: EventAllow, Key: ip, Remaining: remaining})
+	return false, 0, consumed
+}
+
+// banned returns how long ip remains banned. l.mu must be held
+func (l *MemoryLimiter) banned(ip string, now time.Time) time.Duration {
+	if till, ok := l.bans[ip]; ok && now.Before(till) {
+		return till.Sub(now)
+	}
+	return 0
+}
+
+// window returns the current window of ip for limit, starting a new one when
+// it doesn't exist or has expired. l.mu must be held
+func (l *MemoryLimiter) window(ip string, limit Limit, now time.Time) *window {
+	key := limitKey(ip, limit)
+	w := l.windows[key]
+	if w == nil || !now.Before(w.reset) {
+		w = &window{reset: now.Add(limit.Dur)}
+		l.windows[key] = w
+	}
+	return w
+}
+
+// sweep removes expired windows so that keys which are never seen again don't
+// leak. l.mu must be held
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	for key, w := range l.windows {
+		if !now.Before(w.reset) {
+			delete(l.windows, key)
+		}
+	}
+	for ip, till := range l.bans {
+		if !now.Before(till) {
+			delete(l.bans, ip)
+		}
+	}
+	l.nextSweep = now.Add(sweepInterval)
+}
@@ -0,0 +1,182 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimiterLimitsUntilWindowResets(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 2}})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	assert.False(t, l.Limit("a"))
+	assert.False(t, l.Limit("a"))
+	assert.True(t, l.Limit("a"))
+	assert.False(t, l.Limit("b"))
+
+	now = now.Add(time.Minute)
+	assert.False(t, l.Limit("a"))
+}
+
+func TestMemoryLimiterSharesGlobalLimits(t *testing.T) {
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1, Global: true}})
+
+	assert.False(t, l.Limit("a"))
+	assert.True(t, l.Limit("b"))
+}
+
+func TestMemoryLimiterLimitRetryReturnsTimeUntilReset(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	limited, retry, err := l.LimitRetry("a")
+	require.NoError(t, err)
+	assert.False(t, limited)
+	assert.Zero(t, retry)
+
+	now = now.Add(20 * time.Second)
+	limited, retry, err = l.LimitRetry("a")
+	require.NoError(t, err)
+	assert.True(t, limited)
+	assert.Equal(t, 40*time.Second, retry)
+}
+
+func TestMemoryLimiterReservationCancelRefundsEveryLimit(t *testing.T) {
+	l := NewMemoryLimiter([]Limit{
+		{Dur: time.Minute, Limit: 1},
+		{Dur: time.Hour, Limit: 1},
+	})
+
+	res := l.Reserve("a")
+	assert.False(t, res.Limited)
+	require.NoError(t, res.Cancel())
+
+	assert.False(t, l.Limit("a"))
+	assert.True(t, l.Limit("a"))
+}
+
+func TestMemoryLimiterReservationCancelIgnoresNewWindows(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	res := l.Reserve("a")
+	now = now.Add(time.Minute)
+	assert.False(t, l.Limit("a"))
+
+	require.NoError(t, res.Cancel())
+	assert.True(t, l.Limit("a"))
+}
+
+func TestMemoryLimiterSweepsExpiredWindows(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter([]Limit{{Dur: time.Second, Limit: 1}})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	l.Limit("a")
+	now = now.Add(sweepInterval)
+	l.Limit("b")
+
+	assert.Len(t, l.windows, 1)
+}
+
+func TestMemoryLimiterPeekDoesNotConsume(t *testing.T) {
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}})
+
+	assert.False(t, l.Peek("a"))
+	assert.False(t, l.Peek("a"))
+	l.Consume("a")
+	assert.True(t, l.Peek("a"))
+}
+
+func TestMemoryLimiterResetKeepsGlobalLimits(t *testing.T) {
+	l := NewMemoryLimiter([]Limit{
+		{Dur: time.Minute, Limit: 1},
+		{Dur: time.Minute, Limit: 2, Global: true},
+	})
+
+	l.Consume("a")
+	require.NoError(t, l.Reset("a"))
+
+	assert.False(t, l.Limit("a"))
+	assert.True(t, l.Limit("b"))
+}
+
+func TestMemoryLimiterStatusReportsUsage(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter([]Limit{
+		{Dur: time.Minute, Limit: 2},
+		{Dur: time.Hour, Limit: 5},
+	})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	l.Limit("a")
+	now = now.Add(10 * time.Second)
+	require.NoError(t, l.Ban("a", time.Minute))
+
+	status, err := l.Status("a")
+	require.NoError(t, err)
+	assert.Equal(t, Status{
+		Key:    "a",
+		Banned: time.Minute,
+		Limits: []LimitStatus{
+			{Limit: Limit{Dur: time.Hour, Limit: 5}, Count: 1, Remaining: 4, Reset: time.Hour - 10*time.Second},
+			{Limit: Limit{Dur: time.Minute, Limit: 2}, Count: 1, Remaining: 1, Reset: 50 * time.Second},
+		},
+	}, status)
+}
+
+func TestMemoryLimiterBanLimitsUntilExpired(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 10}})
+	l.Clock = funcClock(func() time.Time { return now })
+
+	require.NoError(t, l.Ban("a", time.Hour))
+
+	limited, retry, err := l.LimitRetry("a")
+	require.NoError(t, err)
+	assert.True(t, limited)
+	assert.Equal(t, time.Hour, retry)
+	assert.True(t, l.Peek("a"))
+	assert.False(t, l.Limit("b"))
+
+	now = now.Add(time.Hour)
+	assert.False(t, l.Limit("a"))
+}
+
+func TestMemoryLimiterUnbanLiftsBan(t *testing.T) {
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 10}})
+
+	require.NoError(t, l.Ban("a", time.Hour))
+	require.NoError(t, l.Unban("a"))
+	assert.False(t, l.Limit("a"))
+}
+
+func TestMemoryLimiterResetAllClearsGlobalLimits(t *testing.T) {
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1, Global: true}})
+
+	l.Limit("a")
+	require.NoError(t, l.ResetAll())
+	assert.False(t, l.Limit("b"))
+}
+
+func TestMemoryLimiterCountsNamedLimitsSeparately(t *testing.T) {
+	l := NewMemoryLimiter(MustParseLimits([]string{"login:1/1m", "api:2/1m"}))
+
+	assert.False(t, l.Limit("a"))
+	assert.True(t, l.Limit("a"), "login is exhausted while api has a unit left")
+}
+
+func TestMemoryLimiterCountsNetworks(t *testing.T) {
+	l := NewMemoryLimiter(MustParseLimits([]string{"2/1m/net"}))
+
+	assert.False(t, l.Limit("10.0.0.1"))
+	assert.False(t, l.Limit("10.0.0.2"))
+	assert.True(t, l.Limit("10.0.0.3"))
+	assert.False(t, l.Limit("10.0.1.1"))
+}
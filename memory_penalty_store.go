@@ -0,0 +1,102 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// NewMemoryPenaltyStore creates a properly initialized MemoryPenaltyStore
+func NewMemoryPenaltyStore() *MemoryPenaltyStore {
+	return &MemoryPenaltyStore{
+		penalties: make(map[string]*penalty),
+		Clock:     SystemClock,
+	}
+}
+
+// MemoryPenaltyStore is a PenaltyStore which keeps violations and bans in
+// memory
+type MemoryPenaltyStore struct {
+	Clock Clock
+
+	mu        sync.Mutex
+	penalties map[string]*penalty
+	nextSweep time.Time
+}
+
+type penalty struct {
+	violations int
+	forget     time.Time
+	bannedTill time.Time
+}
+
+// Banned returns how long key remains banned or zero if it isn't banned. The
+// error is always nil
+func (s *MemoryPenaltyStore) Banned(key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.penalties[key]
+	if p == nil {
+		return 0, nil
+	}
+	if now := s.Clock.Now(); now.Before(p.bannedTill) {
+		return p.bannedTill.Sub(now), nil
+	}
+	return 0, nil
+}
+
+// Violate records a violation for key and returns how many violations key
+// has. The error is always nil
+func (s *MemoryPenaltyStore) Violate(key string, decay time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Clock.Now()
+	s.sweep(now)
+
+	p := s.penalty(key)
+	if !now.Before(p.forget) {
+		p.violations = 0
+	}
+	p.violations++
+	p.forget = now.Add(decay)
+	return p.violations, nil
+}
+
+// Ban bans key for dur. It returns ErrInvalidBan unless dur is positive
+func (s *MemoryPenaltyStore) Ban(key string, dur time.Duration) error {
+	if dur <= 0 {
+		return ErrInvalidBan
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.penalty(key).bannedTill = s.Clock.Now().Add(dur)
+	return nil
+}
+
+// penalty returns the penalty of key, creating it if it doesn't exist. s.mu
+// must be held
+func (s *MemoryPenaltyStore) penalty(key string) *penalty {
+	p := s.penalties[key]
+	if p == nil {
+		p = &penalty{}
+		s.penalties[key] = p
+	}
+	return p
+}
+
+// sweep removes penalties which have neither violations nor bans left. s.mu
+// must be held
+func (s *MemoryPenaltyStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	for key, p := range s.penalties {
+		if !now.Before(p.forget) && !now.Before(p.bannedTill) {
+			delete(s.penalties, key)
+		}
+	}
+	s.nextSweep = now.Add(sweepInterval)
+}
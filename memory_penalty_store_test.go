@@ -0,0 +1,59 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryPenaltyStoreBansUntilExpired(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryPenaltyStore()
+	s.Clock = funcClock(func() time.Time { return now })
+
+	require.NoError(t, s.Ban("a", time.Minute))
+	now = now.Add(20 * time.Second)
+
+	banned, err := s.Banned("a")
+	require.NoError(t, err)
+	assert.Equal(t, 40*time.Second, banned)
+
+	now = now.Add(40 * time.Second)
+	banned, err = s.Banned("a")
+	require.NoError(t, err)
+	assert.Zero(t, banned)
+}
+
+func TestMemoryPenaltyStoreCountsViolationsUntilDecay(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryPenaltyStore()
+	s.Clock = funcClock(func() time.Time { return now })
+
+	for i := 1; i <= 3; i++ {
+		n, err := s.Violate("a", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, i, n)
+		now = now.Add(30 * time.Second)
+	}
+
+	now = now.Add(time.Minute)
+	n, err := s.Violate("a", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestMemoryPenaltyStoreSweepsForgottenPenalties(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryPenaltyStore()
+	s.Clock = funcClock(func() time.Time { return now })
+
+	_, err := s.Violate("a", time.Second)
+	require.NoError(t, err)
+	now = now.Add(sweepInterval)
+	_, err = s.Violate("b", time.Second)
+	require.NoError(t, err)
+
+	assert.Len(t, s.penalties, 1)
+}
@@ -0,0 +1,176 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Decision is the outcome of checking a key against a limit
+type Decision string
+
+const (
+	// DecisionAllowed means the limit allowed the key
+	DecisionAllowed Decision = "allowed"
+	// DecisionDenied means the limit ratelimited the key
+	DecisionDenied Decision = "denied"
+	// DecisionWhitelisted means the key was whitelisted and no limit was checked
+	DecisionWhitelisted Decision = "whitelisted"
+	// DecisionErrored means the limit couldn't be checked
+	DecisionErrored Decision = "errored"
+	// DecisionBanned means the key was banned and no limit was checked
+	DecisionBanned Decision = "banned"
+)
+
+// Collector receives measurements from limiters. It can be implemented to
+// bridge limiters to any metrics system
+type Collector interface {
+	// Decision records the outcome of checking a limit, which is identified
+	// by Limit.String(). limit is empty for whitelisted and banned keys
+	Decision(limit string, d Decision)
+	// BackendLatency records how long a call to a backend such as Redis took
+	BackendLatency(backend string, d time.Duration)
+}
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the latency
+// histogram buckets used by NewMetrics
+var DefaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// NewMetrics creates a properly initialized Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Buckets:   DefaultLatencyBuckets,
+		decisions: make(map[decisionLabels]uint64),
+		latencies: make(map[string]*histogram),
+	}
+}
+
+// Metrics is a Collector which keeps its measurements in memory and serves
+// them in the Prometheus text format. Buckets must be sorted and must not be
+// changed after the first measurement
+type Metrics struct {
+	Buckets []float64
+
+	mu        sync.Mutex
+	decisions map[decisionLabels]uint64
+	latencies map[string]*histogram
+}
+
+type decisionLabels struct {
+	limit    string
+	decision Decision
+}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// Decision records the outcome of checking a limit
+func (m *Metrics) Decision(limit string, d Decision) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.decisions[decisionLabels{limit: limit, decision: d}]++
+}
+
+// BackendLatency records how long a call to a backend took
+func (m *Metrics) BackendLatency(backend string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := m.latencies[backend]
+	if h == nil {
+		h = &histogram{buckets: make([]uint64, len(m.Buckets))}
+		m.latencies[backend] = h
+	}
+
+	s := d.Seconds()
+	for i, le := range m.Buckets {
+		if s <= le {
+			h.buckets[i]++
+		}
+	}
+	h.sum += s
+	h.count++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = m.WriteText(w)
+}
+
+// WriteText writes the metrics to w in the Prometheus text format
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP ratelimiter_decisions_total Number of rate limit decisions by limit and outcome.\n")
+	b.WriteString("# TYPE ratelimiter_decisions_total counter\n")
+	decisions := make([]decisionLabels, 0, len(m.decisions))
+	for labels := range m.decisions {
+		decisions = append(decisions, labels)
+	}
+	sort.Slice(decisions, func(i, j int) bool {
+		if decisions[i].limit != decisions[j].limit {
+			return decisions[i].limit < decisions[j].limit
+		}
+		return decisions[i].decision < decisions[j].decision
+	})
+	for _, labels := range decisions {
+		fmt.Fprintf(&b, "ratelimiter_decisions_total{limit=%s,decision=%s} %d\n",
+			quoteLabel(labels.limit), quoteLabel(string(labels.decision)), m.decisions[labels])
+	}
+
+	b.WriteString("# HELP ratelimiter_backend_latency_seconds Latency of rate limit backend calls.\n")
+	b.WriteString("# TYPE ratelimiter_backend_latency_seconds histogram\n")
+	backends := make([]string, 0, len(m.latencies))
+	for backend := range m.latencies {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	for _, backend := range backends {
+		h := m.latencies[backend]
+		label := quoteLabel(backend)
+		for i, le := range m.Buckets {
+			fmt.Fprintf(&b, "ratelimiter_backend_latency_seconds_bucket{backend=%s,le=\"%s\"} %d\n",
+				label, strconv.FormatFloat(le, 'g', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(&b, "ratelimiter_backend_latency_seconds_bucket{backend=%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(&b, "ratelimiter_backend_latency_seconds_sum{backend=%s} %s\n", label, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "ratelimiter_backend_latency_seconds_count{backend=%s} %d\n", label, h.count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// collectDecision records d for limit if c isn't nil
+func collectDecision(c Collector, limit Limit, d Decision) {
+	if c != nil {
+		c.Decision(limit.String(), d)
+	}
+}
+
+// collectLatency records the latency of backend if c isn't nil
+func collectLatency(c Collector, backend string, d time.Duration) {
+	if c != nil {
+		c.BackendLatency(backend, d)
+	}
+}
+
+// quoteLabel quotes a label value as required by the Prometheus text format
+func quoteLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,147 @@
+package ratelimiter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsServesPrometheusText(t *testing.T) {
+	m := NewMetrics()
+	m.Buckets = []float64{.001, .01}
+
+	m.Decision("10/1m0s", DecisionAllowed)
+	m.Decision("10/1m0s", DecisionAllowed)
+	m.Decision("10/1m0s", DecisionDenied)
+	m.Decision("", DecisionWhitelisted)
+	m.BackendLatency("redis", 500*time.Microsecond)
+	m.BackendLatency("redis", 5*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, `# HELP ratelimiter_decisions_total Number of rate limit decisions by limit and outcome.
+# TYPE ratelimiter_decisions_total counter
+ratelimiter_decisions_total{limit="",decision="whitelisted"} 1
+ratelimiter_decisions_total{limit="10/1m0s",decision="allowed"} 2
+ratelimiter_decisions_total{limit="10/1m0s",decision="denied"} 1
+# HELP ratelimiter_backend_latency_seconds Latency of rate limit backend calls.
+# TYPE ratelimiter_backend_latency_seconds histogram
+ratelimiter_backend_latency_seconds_bucket{backend="redis",le="0.001"} 1
+ratelimiter_backend_latency_seconds_bucket{backend="redis",le="0.01"} 2
+ratelimiter_backend_latency_seconds_bucket{backend="redis",le="+Inf"} 2
+ratelimiter_backend_latency_seconds_sum{backend="redis"} 0.0055
+ratelimiter_backend_latency_seconds_count{backend="redis"} 2
+`, w.Body.String())
+}
+
+func TestQuoteLabelEscapes(t *testing.T) {
+	assert.Equal(t, `"a\\b\"c\nd"`, quoteLabel("a\\b\"c\nd"))
+}
+
+func TestRedisLimiterCollectsMetrics(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c := &fakeCollector{}
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.Collector = c
+
+	limiter.Limit("a")
+	limiter.Limit("a")
+
+	assert.Equal(t, []string{"1/1m0s allowed", "1/1m0s denied"}, c.decisions)
+	assert.Equal(t, 2, c.latencies["redis"])
+}
+
+func TestRedisLimiterCollectsErrors(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c := &fakeCollector{}
+	limiter := NewRedisLimiter(&deadPool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.Collector = c
+
+	limiter.Limit("a")
+
+	assert.Equal(t, []string{"1/1m0s errored"}, c.decisions)
+}
+
+func TestMemoryLimiterCollectsMetrics(t *testing.T) {
+	c := &fakeCollector{}
+	limiter := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.Collector = c
+
+	limiter.Limit("a")
+	limiter.Limit("a")
+
+	assert.Equal(t, []string{"1/1m0s allowed", "1/1m0s denied"}, c.decisions)
+}
+
+func TestRedisLimiterCollectsBans(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c := &fakeCollector{}
+	tracer := &recordingTracer{}
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.Collector = c
+	limiter.Tracer = tracer
+	require.NoError(t, limiter.Ban("a", time.Minute))
+
+	assert.True(t, limiter.Limit("a"))
+
+	assert.Equal(t, []string{" banned"}, c.decisions)
+	require.Len(t, tracer.spans, 1)
+	assert.Equal(t, string(DecisionBanned), tracer.spans[0].attrs[AttributeDecision])
+}
+
+func TestMemoryLimiterCollectsBans(t *testing.T) {
+	c := &fakeCollector{}
+	limiter := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.Collector = c
+	require.NoError(t, limiter.Ban("a", time.Minute))
+
+	assert.True(t, limiter.Limit("a"))
+
+	assert.Equal(t, []string{" banned"}, c.decisions)
+}
+
+func TestWhitelistedLimiterCollectsMetrics(t *testing.T) {
+	_, cidr, err := net.ParseCIDR("192.168.1.100/32")
+	require.NoError(t, err)
+
+	c := &fakeCollector{}
+	wl := NewWhitelistedLimiter(&fakeLimiter{}, []*net.IPNet{cidr})
+	wl.Collector = c
+
+	wl.Limit("192.168.1.100")
+
+	assert.Equal(t, []string{" whitelisted"}, c.decisions)
+}
+
+type fakeCollector struct {
+	decisions []string
+	latencies map[string]int
+}
+
+func (f *fakeCollector) Decision(limit string, d Decision) {
+	f.decisions = append(f.decisions, limit+" "+string(d))
+}
+
+func (f *fakeCollector) BackendLatency(backend string, d time.Duration) {
+	if f.latencies == nil {
+		f.latencies = make(map[string]int)
+	}
+	f.latencies[backend]++
+}
@@ -0,0 +1,79 @@
+package ratelimiter
+
+import "time"
+
+// EventType is the kind of an Event
+type EventType string
+
+const (
+	// EventAllow is sent when a key is allowed
+	EventAllow EventType = "allow"
+	// EventDeny is sent when a key is ratelimited
+	EventDeny EventType = "deny"
+	// EventError is sent when a limiter fails to check a key
+	EventError EventType = "error"
+	// EventWhitelist is sent when a key is whitelisted
+	EventWhitelist EventType = "whitelist"
+	// EventBan is sent when a key is banned
+	EventBan EventType = "ban"
+	// EventReset is sent when the counters of a key are reset
+	EventReset EventType = "reset"
+	// EventQueue is sent when a Queue holds a request back
+	EventQueue EventType = "queue"
+)
+
+// Event describes something that happened in a limiter. Only the fields which
+// apply to Type are set
+type Event struct {
+	Type EventType
+	// Key is the key the event is about. It is empty when the counters of
+	// every key are reset
+	Key string
+	// Limit is the limit which denied the key. It is the zero Limit when the
+	// key was denied because it is banned
+	Limit Limit
+	// Remaining is the number of units left for the key in the tightest limit
+	Remaining int
+	// RetryAfter is how long a denied key has to wait or how long a ban lasts
+	RetryAfter time.Duration
+	// Err is the error of EventError
+	Err error
+	// Delay is how long a Queue holds a request back
+	Delay time.Duration
+	// Depth is the number of requests waiting in the queue of a key,
+	// including the request the event is about
+	Depth int
+}
+
+// Observer receives the events of limiters and middleware. Observers are
+// called synchronously, sometimes while the limiter holds a lock, so they must
+// be fast and must not call back into the limiter
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc is an adapter to use an ordinary function as an Observer
+type ObserverFunc func(e Event)
+
+// Observe calls f(e)
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// MultiObserver is an Observer which dispatches every event to each of its
+// observers in order
+type MultiObserver []Observer
+
+// Observe sends e to every observer
+func (m MultiObserver) Observe(e Event) {
+	for _, o := range m {
+		o.Observe(e)
+	}
+}
+
+// observe sends e to o if o isn't nil
+func observe(o Observer, e Event) {
+	if o != nil {
+		o.Observe(e)
+	}
+}
@@ -0,0 +1,51 @@
+//go:build go1.21
+// +build go1.21
+
+package ratelimiter
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogObserver creates an Observer which logs events to logger. Errors are
+// logged at error level, denials and bans at warn level and everything else
+// at debug level
+func NewSlogObserver(logger *slog.Logger) Observer {
+	return &slogObserver{logger: logger}
+}
+
+type slogObserver struct {
+	logger *slog.Logger
+}
+
+func (s *slogObserver) Observe(e Event) {
+	level := slog.LevelDebug
+	attrs := []slog.Attr{
+		slog.String("event", string(e.Type)),
+		slog.String("key", e.Key),
+	}
+
+	switch e.Type {
+	case EventAllow:
+		attrs = append(attrs, slog.Int("remaining", e.Remaining))
+	case EventDeny:
+		level = slog.LevelWarn
+		if e.Limit == (Limit{}) {
+			attrs = append(attrs, slog.Bool("banned", true))
+		} else {
+			attrs = append(attrs, slog.String("limit", e.Limit.String()))
+		}
+		attrs = append(attrs, slog.Duration("retry_after", e.RetryAfter))
+	case EventBan:
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Duration("duration", e.RetryAfter))
+	case EventQueue:
+		attrs = append(attrs, slog.Duration("delay", e.Delay), slog.Int("depth", e.Depth))
+	case EventError:
+		level = slog.LevelError
+		attrs = append(attrs, slog.Any("error", e.Err))
+	}
+
+	s.logger.LogAttrs(context.Background(), level, "ratelimiter", attrs...)
+}
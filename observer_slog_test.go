@@ -0,0 +1,43 @@
+//go:build go1.21
+// +build go1.21
+
+package ratelimiter
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogObserverLogsEvents(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	o := NewSlogObserver(logger)
+
+	o.Observe(Event{Type: EventAllow, Key: "a", Remaining: 3})
+	o.Observe(Event{Type: EventDeny, Key: "a", Limit: Limit{Dur: time.Minute, Limit: 1}, RetryAfter: time.Second})
+	o.Observe(Event{Type: EventDeny, Key: "a", RetryAfter: time.Second})
+	o.Observe(Event{Type: EventBan, Key: "a", RetryAfter: time.Hour})
+	o.Observe(Event{Type: EventQueue, Key: "a", Delay: 100 * time.Millisecond, Depth: 2})
+	o.Observe(Event{Type: EventError, Key: "a", Err: errors.New("boom")})
+
+	assert.Equal(t, `level=DEBUG msg=ratelimiter event=allow key=a remaining=3
+level=WARN msg=ratelimiter event=deny key=a limit=1/1m0s retry_after=1s
+level=WARN msg=ratelimiter event=deny key=a banned=true retry_after=1s
+level=WARN msg=ratelimiter event=ban key=a duration=1h0m0s
+level=DEBUG msg=ratelimiter event=queue key=a delay=100ms depth=2
+level=ERROR msg=ratelimiter event=error key=a error=boom
+`, buf.String())
+}
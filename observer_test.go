@@ -0,0 +1,200 @@
+package ratelimiter
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiObserverDispatchesToEveryObserver(t *testing.T) {
+	a, b := &recordingObserver{}, &recordingObserver{}
+	e := Event{Type: EventAllow, Key: "a"}
+
+	MultiObserver{a, b}.Observe(e)
+
+	assert.Equal(t, []Event{e}, a.events)
+	assert.Equal(t, []Event{e}, b.events)
+}
+
+func TestObserverFuncCallsFunction(t *testing.T) {
+	var result Event
+	e := Event{Type: EventDeny, Key: "a"}
+
+	ObserverFunc(func(e Event) {
+		result = e
+	}).Observe(e)
+
+	assert.Equal(t, e, result)
+}
+
+func TestRedisLimiterSendsEvents(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := Limit{Dur: time.Minute, Limit: 2}
+	o := &recordingObserver{}
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{l, {Dur: time.Hour, Limit: 10}})
+	limiter.Observer = o
+
+	limiter.Limit("a")
+	limiter.Limit("a")
+	limiter.Limit("a")
+	require.NoError(t, limiter.Reset("a"))
+	require.NoError(t, limiter.Ban("a", time.Hour))
+	limiter.Limit("a")
+
+	assert.Equal(t, []Event{
+		{Type: EventAllow, Key: "a", Remaining: 1},
+		{Type: EventAllow, Key: "a", Remaining: 0},
+		{Type: EventDeny, Key: "a", Limit: l, RetryAfter: time.Minute},
+		{Type: EventReset, Key: "a"},
+		{Type: EventBan, Key: "a", RetryAfter: time.Hour},
+		{Type: EventDeny, Key: "a", RetryAfter: time.Hour},
+	}, o.events)
+}
+
+func TestRedisLimiterSendsErrorEvents(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	o := &recordingObserver{}
+	limiter := NewRedisLimiter(&deadPool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.Observer = o
+
+	limiter.Limit("a")
+
+	require.Len(t, o.events, 1)
+	assert.Equal(t, EventError, o.events[0].Type)
+	assert.Error(t, o.events[0].Err)
+}
+
+func TestMemoryLimiterSendsEvents(t *testing.T) {
+	now := time.Now()
+	l := Limit{Dur: time.Minute, Limit: 2}
+	o := &recordingObserver{}
+	limiter := NewMemoryLimiter([]Limit{l, {Dur: time.Hour, Limit: 10}})
+	limiter.Clock = funcClock(func() time.Time { return now })
+	limiter.Observer = o
+
+	limiter.Limit("a")
+	limiter.Limit("a")
+	limiter.Limit("a")
+	require.NoError(t, limiter.Reset("a"))
+	require.NoError(t, limiter.ResetAll())
+	require.NoError(t, limiter.Ban("a", time.Hour))
+	limiter.Limit("a")
+
+	assert.Equal(t, []Event{
+		{Type: EventAllow, Key: "a", Remaining: 1},
+		{Type: EventAllow, Key: "a", Remaining: 0},
+		{Type: EventDeny, Key: "a", Limit: l, RetryAfter: time.Minute},
+		{Type: EventReset, Key: "a"},
+		{Type: EventReset},
+		{Type: EventBan, Key: "a", RetryAfter: time.Hour},
+		{Type: EventDeny, Key: "a", RetryAfter: time.Hour},
+	}, o.events)
+}
+
+func TestWhitelistedLimiterSendsEvents(t *testing.T) {
+	_, cidr, err := net.ParseCIDR("192.168.1.100/32")
+	require.NoError(t, err)
+
+	o := &recordingObserver{}
+	wl := NewWhitelistedLimiter(&fakeLimiter{}, []*net.IPNet{cidr})
+	wl.Observer = o
+
+	wl.Limit("192.168.1.100")
+	wl.Limit("192.168.1.101")
+
+	assert.Equal(t, []Event{{Type: EventWhitelist, Key: "192.168.1.100"}}, o.events)
+}
+
+func TestPenaltyLimiterSendsEvents(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryPenaltyStore()
+	store.Clock = funcClock(func() time.Time { return now })
+
+	o := &recordingObserver{}
+	p := NewPenaltyLimiter(&fakeLimiter{
+		LimitFunc: func(string) bool {
+			return true
+		},
+	}, store)
+	p.Observer = o
+
+	p.Limit("a")
+	p.Limit("a")
+
+	assert.Equal(t, []Event{
+		{Type: EventBan, Key: "a", RetryAfter: time.Minute},
+		{Type: EventDeny, Key: "a", RetryAfter: time.Minute},
+	}, o.events)
+}
+
+func TestBreakerLimiterSendsErrorEvents(t *testing.T) {
+	expected := errors.New("boom")
+	o := &recordingObserver{}
+	b := newTestBreaker(&fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return false, 0, expected
+		},
+	})
+	b.Observer = o
+
+	b.Limit("a")
+
+	assert.Equal(t, []Event{{Type: EventError, Key: "a", Err: expected}}, o.events)
+}
+
+func TestMiddlewareSendsEvents(t *testing.T) {
+	o := &recordingObserver{}
+	mw := Middleware(NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}}), WithObserver(o))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "127.0.0.1:22826"
+		mw(&fakeHandler{}).ServeHTTP(w, r)
+	}
+
+	assert.Equal(t, []Event{
+		{Type: EventAllow, Key: "127.0.0.1"},
+		{Type: EventDeny, Key: "127.0.0.1"},
+	}, o.events)
+}
+
+func TestMiddlewareSendsResetEvents(t *testing.T) {
+	o := &recordingObserver{}
+	mw := Middleware(NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}}),
+		CountIf(StatusIn(http.StatusUnauthorized)),
+		ResetIf(StatusIn(http.StatusOK)),
+		WithObserver(o),
+	)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:22826"
+	mw(&fakeHandler{}).ServeHTTP(w, r)
+
+	assert.Equal(t, []Event{
+		{Type: EventAllow, Key: "127.0.0.1"},
+		{Type: EventReset, Key: "127.0.0.1"},
+	}, o.events)
+}
+
+type recordingObserver struct {
+	events []Event
+}
+
+func (r *recordingObserver) Observe(e Event) {
+	r.events = append(r.events, e)
+}
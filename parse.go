@@ -20,45 +20,195 @@ var (
 
 	// ErrInvalidDuration is used when the duration is < 0s
 	ErrInvalidDuration = errors.New("duration must be > 0s")
+
+	// ErrMalformedDuration is used when the duration can't be parsed
+	ErrMalformedDuration = errors.New("invalid duration")
+
+	// ErrUnknownScope is used when the scope after the duration is unknown
+	ErrUnknownScope = errors.New("unknown scope")
+
+	// ErrMalformedName is used when the name before the limit contains
+	// anything other than letters, digits, '-', '_' and '.'
+	ErrMalformedName = errors.New("malformed name")
 )
 
+// ParseError describes a limit string which failed to parse
+type ParseError struct {
+	// Limit is the limit string
+	Limit string
+	// Pos is the byte offset in Limit of the part which failed to parse
+	Pos int
+	// Err is the reason, such as ErrMalformedLimitNumber
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid limit %q at position %d: %s", e.Limit, e.Pos, e.Err)
+}
+
+// Unwrap returns e.Err
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// durationUnits are the units accepted in the duration of a limit
+var durationUnits = map[string]time.Duration{
+	"ns":      time.Nanosecond,
+	"us":      time.Microsecond,
+	"µs":      time.Microsecond,
+	"ms":      time.Millisecond,
+	"s":       time.Second,
+	"sec":     time.Second,
+	"second":  time.Second,
+	"seconds": time.Second,
+	"m":       time.Minute,
+	"min":     time.Minute,
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"h":       time.Hour,
+	"hr":      time.Hour,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+	"d":       24 * time.Hour,
+	"day":     24 * time.Hour,
+	"days":    24 * time.Hour,
+	"w":       7 * 24 * time.Hour,
+	"week":    7 * 24 * time.Hour,
+	"weeks":   7 * 24 * time.Hour,
+	"mo":      30 * 24 * time.Hour,
+	"month":   30 * 24 * time.Hour,
+	"months":  30 * 24 * time.Hour,
+}
+
 // ParseLimit parses a limiter string
-// limit should be in the format of <count>/<duration>/g where count is the
-// maximum count of requests, duration is the length of time, and /g means that
-// the limiter is global and not ip specific
+// limit should be in the format of [name:]<count>/<duration>[/scope] where
+// name is an optional label, count is the maximum count of requests, duration
+// is the length of time, and scope is g or global which means that the
+// limiter is global and not ip specific, n or net which counts ip addresses
+// per network (see Limit.Network), or k or key which is the default.
+// count may be followed by r as in nginx. Durations accept the units of
+// time.ParseDuration as well as d (days), w (weeks) and mo (30 days) and
+// their long forms. A duration without a number means one of the unit
 //
 // Example:
 //    1/1m    = one request per minute per IP address
 //    10/24h/g = ten requests per day globally
+//    100/s    = one hundred requests per second
+//    5r/m     = five requests per minute
+//    1000/1w  = one thousand requests per week
+//    login:5/1m = five requests per minute named login
+//    50/1m/net  = fifty requests per minute per /24 or /64 network
+//
+// Errors are of type *ParseError, which unwraps to one of the Err variables
+// of this package. Use errors.Is instead of comparing errors with ==, which
+// worked before ParseError was introduced
 func ParseLimit(limit string) (Limit, error) {
-	sp := strings.Split(limit, "/")
-	if len(sp) < 2 {
-		return Limit{}, ErrMalformedLimit
+	fail := func(pos int, err error) (Limit, error) {
+		return Limit{}, &ParseError{Limit: limit, Pos: pos, Err: err}
 	}
 
-	max, err := strconv.Atoi(sp[0])
+	var l Limit
+	pos := 0
+	if i := strings.IndexByte(limit, ':'); i >= 0 {
+		l.Name = limit[:i]
+		if !validName(l.Name) {
+			return fail(0, ErrMalformedName)
+		}
+		pos = i + 1
+	}
+
+	sp := strings.Split(limit[pos:], "/")
+	if len(sp) < 2 || len(sp) > 3 {
+		return fail(pos, ErrMalformedLimit)
+	}
+
+	count := strings.TrimSuffix(sp[0], "r")
+	max, err := strconv.Atoi(count)
 	if err != nil {
-		return Limit{}, fmt.Errorf("%s: %s", ErrMalformedLimitNumber, err)
+		return fail(pos, ErrMalformedLimitNumber)
 	}
 	if max < 1 {
-		return Limit{}, ErrInvalidLimitNumber
+		return fail(pos, ErrInvalidLimitNumber)
 	}
+	l.Limit = max
+	pos += len(sp[0]) + 1
 
-	dur, err := time.ParseDuration(sp[1])
+	l.Dur, err = ParseDuration(sp[1])
 	if err != nil {
-		return Limit{}, fmt.Errorf("%s: %s", "invalid duration", err)
+		return fail(pos, err)
+	}
+	if l.Dur.Seconds() < 1 {
+		return fail(pos, ErrInvalidDuration)
 	}
-	if dur.Seconds() < 1 {
-		return Limit{}, ErrInvalidDuration
+	pos += len(sp[1]) + 1
+
+	if len(sp) == 3 {
+		switch sp[2] {
+		case "g", "global":
+			l.Global = true
+		case "n", "net":
+			l.Network = true
+		case "k", "key":
+		default:
+			return fail(pos, ErrUnknownScope)
+		}
 	}
 
-	g := len(sp) == 3 && sp[2] == "g"
+	return l, nil
+}
+
+// ParseDuration parses a duration the way ParseLimit does, as a sequence of
+// numbers and units such as 1d12h. A unit without a number means one of the
+// unit. Errors unwrap to ErrMalformedDuration
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, ErrMalformedDuration
+	}
+	if _, ok := durationUnits[s]; ok {
+		return durationUnits[s], nil
+	}
+
+	var total time.Duration
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.'
+		})
+		if i <= 0 {
+			return 0, ErrMalformedDuration
+		}
+		n, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, ErrMalformedDuration
+		}
+		s = s[i:]
+
+		j := strings.IndexAny(s, "0123456789.")
+		if j < 0 {
+			j = len(s)
+		}
+		unit, ok := durationUnits[s[:j]]
+		if !ok {
+			return 0, fmt.Errorf("%w: unknown unit %q", ErrMalformedDuration, s[:j])
+		}
+		s = s[j:]
+		total += time.Duration(n * float64(unit))
+	}
+	return total, nil
+}
 
-	return Limit{
-		Global: g,
-		Limit:  max,
-		Dur:    dur,
-	}, nil
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 // ParseLimits parses a slice of limits with ParseLimit
@@ -1,10 +1,12 @@
 package ratelimiter
 
 import (
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseLimitErrors(t *testing.T) {
@@ -135,3 +137,90 @@ func TestMustParseLimitParses(t *testing.T) {
 	res := MustParseLimit(raw)
 	assert.EqualValues(t, expected, res)
 }
+
+func TestParseLimitGrammar(t *testing.T) {
+	day := 24 * time.Hour
+	tests := map[string]Limit{
+		"1/1d":           {Limit: 1, Dur: day},
+		"100/1w":         {Limit: 100, Dur: 7 * day},
+		"1000/1mo/g":     {Limit: 1000, Dur: 30 * day, Global: true},
+		"10/1d12h":       {Limit: 10, Dur: 36 * time.Hour},
+		"100/s":          {Limit: 100, Dur: time.Second},
+		"1000/hour":      {Limit: 1000, Dur: time.Hour},
+		"5/2days":        {Limit: 5, Dur: 2 * day},
+		"5r/s":           {Limit: 5, Dur: time.Second},
+		"30r/m":          {Limit: 30, Dur: time.Minute},
+		"10/1.5h":        {Limit: 10, Dur: 90 * time.Minute},
+		"login:5/1m":     {Limit: 5, Dur: time.Minute, Name: "login"},
+		"api.v1:10/s/g":  {Limit: 10, Dur: time.Second, Global: true, Name: "api.v1"},
+		"10/1m/global":   {Limit: 10, Dur: time.Minute, Global: true},
+		"10/1m/key":      {Limit: 10, Dur: time.Minute},
+		"10/1m/k":        {Limit: 10, Dur: time.Minute},
+		"10/1h30m/g":     {Limit: 10, Dur: 90 * time.Minute, Global: true},
+		"2/1500ms":       {Limit: 2, Dur: 1500 * time.Millisecond},
+		"burst-1:3/10s":  {Limit: 3, Dur: 10 * time.Second, Name: "burst-1"},
+		"10/24h0m0s/g":   {Limit: 10, Dur: day, Global: true},
+		"10/720h0m0s":    {Limit: 10, Dur: 30 * day},
+		"7/minutes/key":  {Limit: 7, Dur: time.Minute},
+		"limit_1:1/week": {Limit: 1, Dur: 7 * day, Name: "limit_1"},
+		"50/1m/net":      {Limit: 50, Dur: time.Minute, Network: true},
+		"ssh:3/1m/n":     {Limit: 3, Dur: time.Minute, Network: true, Name: "ssh"},
+	}
+
+	for raw, limit := range tests {
+		t.Run(raw, func(t *testing.T) {
+			res, err := ParseLimit(raw)
+			require.NoError(t, err)
+			assert.Equal(t, limit, res)
+
+			// String always parses back to the same limit
+			again, err := ParseLimit(res.String())
+			require.NoError(t, err)
+			assert.Equal(t, res, again)
+		})
+	}
+}
+
+func TestParseLimitReturnsParseError(t *testing.T) {
+	tests := []struct {
+		raw string
+		pos int
+		err error
+	}{
+		{raw: "x/1m", pos: 0, err: ErrMalformedLimitNumber},
+		{raw: "0/1m", pos: 0, err: ErrInvalidLimitNumber},
+		{raw: "1/1y", pos: 2, err: ErrMalformedDuration},
+		{raw: "1/m1", pos: 2, err: ErrMalformedDuration},
+		{raw: "1/500ms", pos: 2, err: ErrInvalidDuration},
+		{raw: "1/1m/q", pos: 5, err: ErrUnknownScope},
+		{raw: "login:1/1m/q", pos: 11, err: ErrUnknownScope},
+		{raw: "login:1/1y", pos: 8, err: ErrMalformedDuration},
+		{raw: "bad name:1/1m", pos: 0, err: ErrMalformedName},
+		{raw: "login:1", pos: 6, err: ErrMalformedLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			_, err := ParseLimit(tt.raw)
+			var perr *ParseError
+			require.True(t, errors.As(err, &perr))
+			assert.Equal(t, tt.raw, perr.Limit)
+			assert.Equal(t, tt.pos, perr.Pos)
+			assert.True(t, errors.Is(err, tt.err), err.Error())
+		})
+	}
+}
+
+func TestParseErrorMessage(t *testing.T) {
+	_, err := ParseLimit("1/1y")
+	assert.EqualError(t, err, `invalid limit "1/1y" at position 2: invalid duration: unknown unit "y"`)
+}
+
+func TestParseDuration(t *testing.T) {
+	d, err := ParseDuration("1d12h")
+	require.NoError(t, err)
+	assert.Equal(t, 36*time.Hour, d)
+
+	_, err = ParseDuration("1y")
+	assert.True(t, errors.Is(err, ErrMalformedDuration))
+}
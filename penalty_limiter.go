@@ -0,0 +1,133 @@
+package ratelimiter
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrInvalidBan is returned when a key is banned for a duration which isn't
+// positive
+var ErrInvalidBan = errors.New("ban duration must be positive")
+
+// PenaltyStore keeps track of the violations and bans of PenaltyLimiter
+type PenaltyStore interface {
+	// Banned returns how long key remains banned or zero if it isn't banned
+	Banned(key string) (time.Duration, error)
+	// Violate records a violation for key and returns how many violations
+	// key has. Violations are forgotten once key hasn't violated a limit for
+	// decay
+	Violate(key string, decay time.Duration) (int, error)
+	// Ban bans key for dur. It returns ErrInvalidBan unless dur is positive
+	Ban(key string, dur time.Duration) error
+}
+
+// NewPenaltyLimiter creates a properly initialized PenaltyLimiter which bans
+// for one minute after the first violation, ten minutes after the second and
+// one hour after that. Violations are forgotten after a day. Keys are limited
+// when the wrapped limiter fails
+func NewPenaltyLimiter(limiter Limiter, store PenaltyStore) *PenaltyLimiter {
+	return &PenaltyLimiter{
+		Limiter:      limiter,
+		Store:        store,
+		BaseBan:      time.Minute,
+		Factor:       10,
+		MaxBan:       time.Hour,
+		Decay:        24 * time.Hour,
+		LimitOnError: true,
+	}
+}
+
+// PenaltyLimiter wraps a ratelimiter and bans keys which keep going over the
+// limit. Every time the wrapped limiter limits a key it counts as a violation
+// and the key is banned for BaseBan * Factor^(violations-1) up to MaxBan.
+// Bans escalate per key so PenaltyLimiter should wrap limiters with per key
+// limits rather than Global ones
+type PenaltyLimiter struct {
+	Limiter      Limiter
+	Store        PenaltyStore
+	BaseBan      time.Duration
+	Factor       float64
+	MaxBan       time.Duration
+	Decay        time.Duration
+	LimitOnError bool
+	OnBan        func(key string, dur time.Duration)
+	OnError      func(key string, err error)
+	Observer     Observer
+}
+
+// Limit returns true for banned keys. Otherwise it defers to p.Limiter.Limit
+// and bans the key if it is limited. Errors of p.Limiter are reported to
+// OnError and return LimitOnError
+func (p *PenaltyLimiter) Limit(key string) bool {
+	limited, _, err := p.LimitRetry(key)
+	if err != nil {
+		p.error(key, err)
+		return p.LimitOnError
+	}
+	return limited
+}
+
+// LimitRetry works like Limit but also returns how long key must wait before
+// it will be allowed again. Errors of the store are reported to OnError and
+// never limit a key by themselves so the error is always nil unless
+// p.Limiter returns one
+func (p *PenaltyLimiter) LimitRetry(key string) (bool, time.Duration, error) {
+	banned, err := p.Store.Banned(key)
+	if err != nil {
+		p.error(key, err)
+	}
+	if banned > 0 {
+		observe(p.Observer, Event{Type: EventDeny, Key: key, RetryAfter: banned})
+		return true, banned, nil
+	}
+
+	limited, retry, err := p.limitRetry(key)
+	if err != nil || !limited {
+		return limited, retry, err
+	}
+
+	violations, err := p.Store.Violate(key, p.Decay)
+	if err != nil {
+		p.error(key, err)
+		return true, retry, nil
+	}
+
+	dur := p.banDuration(violations)
+	if err := p.Store.Ban(key, dur); err != nil {
+		p.error(key, err)
+		return true, retry, nil
+	}
+	if p.OnBan != nil {
+		p.OnBan(key, dur)
+	}
+	observe(p.Observer, Event{Type: EventBan, Key: key, RetryAfter: dur})
+	return true, dur, nil
+}
+
+func (p *PenaltyLimiter) limitRetry(key string) (bool, time.Duration, error) {
+	if r, ok := p.Limiter.(RetryLimiter); ok {
+		return r.LimitRetry(key)
+	}
+	return p.Limiter.Limit(key), 0, nil
+}
+
+// banDuration returns the ban for the given number of violations
+func (p *PenaltyLimiter) banDuration(violations int) time.Duration {
+	if violations < 1 {
+		violations = 1
+	}
+
+	dur := float64(p.BaseBan) * math.Pow(p.Factor, float64(violations-1))
+	if p.MaxBan > 0 && dur > float64(p.MaxBan) {
+		return p.MaxBan
+	}
+	return time.Duration(dur)
+}
+
+func (p *PenaltyLimiter) error(key string, err error) {
+	if p.OnError != nil {
+		p.OnError(key, err)
+	}
+	observe(p.Observer, Event{Type: EventError, Key: key, Err: err})
+}
@@ -0,0 +1,133 @@
+package ratelimiter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPenaltyLimiterEscalatesBans(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryPenaltyStore()
+	store.Clock = funcClock(func() time.Time { return now })
+
+	var bans []time.Duration
+	p := NewPenaltyLimiter(&fakeLimiter{
+		LimitFunc: func(string) bool {
+			return true
+		},
+	}, store)
+	p.OnBan = func(key string, dur time.Duration) {
+		assert.Equal(t, "a", key)
+		bans = append(bans, dur)
+	}
+
+	for i := 0; i < 4; i++ {
+		limited, retry, err := p.LimitRetry("a")
+		require.NoError(t, err)
+		assert.True(t, limited)
+		assert.Equal(t, bans[i], retry)
+
+		now = now.Add(retry)
+	}
+
+	assert.Equal(t, []time.Duration{time.Minute, 10 * time.Minute, time.Hour, time.Hour}, bans)
+}
+
+func TestPenaltyLimiterDoesNotAskLimiterWhileBanned(t *testing.T) {
+	calls := 0
+	p := NewPenaltyLimiter(&fakeLimiter{
+		LimitFunc: func(string) bool {
+			calls++
+			return true
+		},
+	}, NewMemoryPenaltyStore())
+
+	assert.True(t, p.Limit("a"))
+	assert.True(t, p.Limit("a"))
+	assert.Equal(t, 1, calls)
+}
+
+func TestPenaltyLimiterForgetsViolationsAfterDecay(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryPenaltyStore()
+	store.Clock = funcClock(func() time.Time { return now })
+
+	var bans []time.Duration
+	p := NewPenaltyLimiter(&fakeLimiter{
+		LimitFunc: func(string) bool {
+			return true
+		},
+	}, store)
+	p.OnBan = func(key string, dur time.Duration) {
+		bans = append(bans, dur)
+	}
+
+	p.Limit("a")
+	now = now.Add(p.Decay)
+	p.Limit("a")
+
+	assert.Equal(t, []time.Duration{time.Minute, time.Minute}, bans)
+}
+
+func TestPenaltyLimiterAllowsWhenLimiterAllows(t *testing.T) {
+	p := NewPenaltyLimiter(&fakeLimiter{}, NewMemoryPenaltyStore())
+	p.OnBan = func(string, time.Duration) {
+		t.Fatal("unexpected ban")
+	}
+
+	assert.False(t, p.Limit("a"))
+}
+
+func TestPenaltyLimiterReportsStoreErrors(t *testing.T) {
+	expected := errors.New("boom")
+	p := NewPenaltyLimiter(&fakeLimiter{}, &fakePenaltyStore{err: expected})
+
+	var result error
+	p.OnError = func(key string, err error) {
+		result = err
+	}
+
+	assert.False(t, p.Limit("a"))
+	assert.Equal(t, expected, result)
+}
+
+type fakePenaltyStore struct {
+	err error
+}
+
+func (f *fakePenaltyStore) Banned(string) (time.Duration, error) {
+	return 0, f.err
+}
+
+func (f *fakePenaltyStore) Violate(string, time.Duration) (int, error) {
+	return 0, f.err
+}
+
+func (f *fakePenaltyStore) Ban(string, time.Duration) error {
+	return f.err
+}
+
+func TestPenaltyLimiterHandlesErrorsWithoutCheckingAgain(t *testing.T) {
+	fake := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return false, 0, errors.New("boom")
+		},
+	}
+	var errKey string
+	p := NewPenaltyLimiter(fake, NewMemoryPenaltyStore())
+	p.OnError = func(key string, err error) {
+		errKey = key
+	}
+
+	assert.True(t, p.Limit("a"))
+	assert.Equal(t, "a", errKey)
+	assert.Equal(t, 1, fake.calls)
+
+	p.LimitOnError = false
+	assert.False(t, p.Limit("a"))
+	assert.Equal(t, 2, fake.calls)
+}
@@ -0,0 +1,263 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Priority is the class of a request. Higher classes are shed last
+type Priority int
+
+const (
+	// PriorityLow is for traffic which can be dropped first, such as batch
+	// jobs
+	PriorityLow Priority = iota
+	// PriorityNormal is the default class
+	PriorityNormal
+	// PriorityHigh is for traffic such as paying customers
+	PriorityHigh
+	// PriorityCritical is for traffic which must get through, such as health
+	// checks
+	PriorityCritical
+)
+
+// PrioritizedLimiter is a Limiter which takes the class of a request into
+// account. Middleware uses it together with WithPriority
+type PrioritizedLimiter interface {
+	Limiter
+	LimitPriority(key string, p Priority) bool
+}
+
+// PriorityFunc returns the class of a request
+type PriorityFunc func(r *http.Request) Priority
+
+// PriorityByHeader returns the class named by the header name in classes.
+// Requests without the header or with an unknown value get def
+func PriorityByHeader(name string, classes map[string]Priority, def Priority) PriorityFunc {
+	return func(r *http.Request) Priority {
+		if p, ok := classes[r.Header.Get(name)]; ok {
+			return p
+		}
+		return def
+	}
+}
+
+// PriorityByKey returns the class fn assigns to the key of a request, such as
+// the plan of an API key
+func PriorityByKey(key KeyFunc, fn func(key string) Priority) PriorityFunc {
+	return func(r *http.Request) Priority {
+		return fn(key(r))
+	}
+}
+
+// NewPriorityLimiter creates a properly initialized PriorityLimiter which
+// checks keys with limiter and shares global between the classes in shares.
+// global is copied and left untouched. Global is counted in memory
+func NewPriorityLimiter(limiter Limiter, global []Limit, shares map[Priority]float64) *PriorityLimiter {
+	global = append([]Limit(nil), global...)
+	sort.Sort(byDuration(global))
+	for i := range global {
+		global[i].Global = true
+	}
+
+	return &PriorityLimiter{
+		Limiter: limiter,
+		Global:  global,
+		Shares:  shares,
+		Default: PriorityNormal,
+		Clock:   SystemClock,
+		windows: make([]priorityWindow, len(global)),
+	}
+}
+
+// PriorityLimiter sheds low priority traffic before high priority traffic
+// when the Global limits, which are shared by every key, run out. Every class
+// has the share of Global in Shares reserved for it. Once a class has used up
+// its share it may borrow what is left of Global, except for the unused
+// shares of higher classes. Lower classes are therefore rejected first as
+// Global runs out, and every class is guaranteed its share against lower
+// classes. Classes without a share only borrow.
+//
+// Keys which pass Global are then checked by Limiter, if it is set, and give
+// back their unit of Global when Limiter rejects them. Limit checks with
+// Default.
+//
+// Global is counted with fixed windows. Without Pool the windows are kept in
+// memory like MemoryLimiter and Global is the quota of a single process. With
+// Pool every instance counts in the same windows in Redis, which start at
+// multiples of their duration by Clock, so the clocks of all instances should
+// be in sync. Errors of Redis are reported to OnError and shed the class when
+// LimitOnError is true
+type PriorityLimiter struct {
+	Limiter      Limiter
+	Global       []Limit
+	Shares       map[Priority]float64
+	Default      Priority
+	Pool         redisPool
+	LimitOnError bool
+	OnError      func(key string, err error)
+	Observer     Observer
+	Clock        Clock
+
+	mu      sync.Mutex
+	windows []priorityWindow
+}
+
+// priorityWindow counts the units consumed by every class until reset
+type priorityWindow struct {
+	used  map[Priority]int
+	total int
+	reset time.Time
+}
+
+// Limit checks key with the Default class
+func (l *PriorityLimiter) Limit(key string) bool {
+	return l.LimitPriority(key, l.Default)
+}
+
+// LimitPriority checks key with class p. It returns true if key should be
+// ratelimited and false otherwise
+func (l *PriorityLimiter) LimitPriority(key string, p Priority) bool {
+	take := l.take
+	if l.Pool != nil {
+		take = l.takeShared
+	}
+
+	shed, giveBack := take(key, p)
+	if shed {
+		return true
+	}
+	if l.Limiter != nil && l.Limiter.Limit(key) {
+		giveBack()
+		return true
+	}
+	return false
+}
+
+// Used returns the units of every Global limit consumed by class p in the
+// current windows. Errors of Redis are reported to OnError and count as zero
+func (l *PriorityLimiter) Used(p Priority) []int {
+	if l.Pool != nil {
+		used, err := l.usedShared(p)
+		if err != nil {
+			l.reportError("", err)
+			return make([]int, len(l.Global))
+		}
+		return used
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Clock.Now()
+	used := make([]int, len(l.Global))
+	for i := range l.Global {
+		used[i] = l.window(i, now).used[p]
+	}
+	return used
+}
+
+// take consumes a unit of every Global limit for class p unless one of them
+// sheds p. It returns true when p was shed, and otherwise a function which
+// gives the units back
+func (l *PriorityLimiter) take(key string, p Priority) (bool, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Clock.Now()
+	remaining := -1
+	for i, limit := range l.Global {
+		w := l.window(i, now)
+		left := l.available(limit, w, p)
+		if left <= 0 {
+			observe(l.Observer, Event{Type: EventDeny, Key: key, Limit: limit, RetryAfter: w.reset.Sub(now)})
+			return true, nil
+		}
+		if remaining < 0 || left-1 < remaining {
+			remaining = left - 1
+		}
+	}
+
+	resets := make([]time.Time, len(l.Global))
+	for i := range l.Global {
+		w := &l.windows[i]
+		w.used[p]++
+		w.total++
+		resets[i] = w.reset
+	}
+	observe(l.Observer, Event{Type: EventAllow, Key: key, Remaining: remaining})
+	return false, func() { l.giveBack(p, resets) }
+}
+
+// giveBack returns the units taken for class p. Units are only given back to
+// the windows with the resets they were taken from, not to newer windows
+func (l *PriorityLimiter) giveBack(p Priority, resets []time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := range l.windows {
+		w := &l.windows[i]
+		if w.reset.Equal(resets[i]) && w.used[p] > 0 {
+			w.used[p]--
+			w.total--
+		}
+	}
+}
+
+// available returns how many more units class p may take from w. l.mu must be
+// held
+func (l *PriorityLimiter) available(limit Limit, w *priorityWindow, p Priority) int {
+	free := limit.Limit - w.total
+	if own := l.reserved(limit, p) - w.used[p]; own > 0 {
+		// a class can always use its own share while there is room
+		if own < free {
+			return own + l.borrowable(limit, w, p, free-own)
+		}
+		return free
+	}
+	return l.borrowable(limit, w, p, free)
+}
+
+// borrowable returns how many of free units class p may borrow without
+// touching the unused shares of higher classes. l.mu must be held
+func (l *PriorityLimiter) borrowable(limit Limit, w *priorityWindow, p Priority, free int) int {
+	for q := range l.Shares {
+		if q <= p {
+			continue
+		}
+		if unused := l.reserved(limit, q) - w.used[q]; unused > 0 {
+			free -= unused
+		}
+	}
+	if free < 0 {
+		return 0
+	}
+	return free
+}
+
+// reserved returns the units of limit reserved for class p
+func (l *PriorityLimiter) reserved(limit Limit, p Priority) int {
+	return int(l.Shares[p] * float64(limit.Limit))
+}
+
+// window returns the current window of the Global limit at i, starting a new
+// one when it has expired. l.mu must be held
+func (l *PriorityLimiter) window(i int, now time.Time) *priorityWindow {
+	w := &l.windows[i]
+	if w.used == nil || !now.Before(w.reset) {
+		*w = priorityWindow{
+			used:  make(map[Priority]int),
+			reset: now.Add(l.Global[i].Dur),
+		}
+	}
+	return w
+}
+
+func (l *PriorityLimiter) reportError(key string, err error) {
+	if l.OnError != nil {
+		l.OnError(key, err)
+	}
+	observe(l.Observer, Event{Type: EventError, Key: key, Err: err})
+}
@@ -0,0 +1,134 @@
+package ratelimiter
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPriorityLimiter(limiter Limiter, limit int, shares map[Priority]float64) *PriorityLimiter {
+	return NewPriorityLimiter(limiter, []Limit{{Dur: time.Minute, Limit: limit}}, shares)
+}
+
+// admit checks key n times with class p and returns how many were allowed
+func admit(l *PriorityLimiter, key string, p Priority, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if !l.LimitPriority(key, p) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestPriorityLimiterShedsLowerClassesFirst(t *testing.T) {
+	l := newTestPriorityLimiter(nil, 10, map[Priority]float64{
+		PriorityHigh: 0.3,
+	})
+
+	// low can't take the share of high
+	assert.Equal(t, 7, admit(l, "a", PriorityLow, 10))
+	assert.Equal(t, 3, admit(l, "b", PriorityHigh, 10))
+}
+
+func TestPriorityLimiterHigherClassesBorrowFromLower(t *testing.T) {
+	l := newTestPriorityLimiter(nil, 10, map[Priority]float64{
+		PriorityLow:  0.3,
+		PriorityHigh: 0.3,
+	})
+
+	assert.Equal(t, 10, admit(l, "a", PriorityHigh, 20))
+	assert.Equal(t, 0, admit(l, "b", PriorityLow, 1), "the share of a class is only guaranteed against lower classes")
+}
+
+func TestPriorityLimiterGuaranteesShares(t *testing.T) {
+	l := newTestPriorityLimiter(nil, 10, map[Priority]float64{
+		PriorityNormal:   0.2,
+		PriorityCritical: 0.2,
+	})
+
+	assert.Equal(t, 6, admit(l, "a", PriorityLow, 10))
+	assert.Equal(t, 2, admit(l, "b", PriorityNormal, 10))
+	assert.Equal(t, 2, admit(l, "c", PriorityCritical, 10))
+	assert.Equal(t, []int{6}, l.Used(PriorityLow))
+}
+
+func TestPriorityLimiterResetsWindows(t *testing.T) {
+	now := time.Now()
+	l := newTestPriorityLimiter(nil, 2, nil)
+	l.Clock = funcClock(func() time.Time { return now })
+
+	assert.Equal(t, 2, admit(l, "a", PriorityNormal, 3))
+	now = now.Add(time.Minute)
+	assert.Equal(t, 2, admit(l, "a", PriorityNormal, 3))
+}
+
+func TestPriorityLimiterGivesBackUnitsRejectedByLimiter(t *testing.T) {
+	limiter := &fakeLimiter{
+		LimitFunc: func(key string) bool {
+			return key == "abuser"
+		},
+	}
+	l := newTestPriorityLimiter(limiter, 2, nil)
+
+	assert.Equal(t, 0, admit(l, "abuser", PriorityNormal, 5))
+	assert.Equal(t, 2, admit(l, "a", PriorityNormal, 5))
+}
+
+func TestPriorityLimiterSendsEvents(t *testing.T) {
+	o := &recordingObserver{}
+	l := newTestPriorityLimiter(nil, 2, nil)
+	l.Clock = funcClock(func() time.Time { return time.Unix(0, 0) })
+	l.Observer = o
+
+	admit(l, "a", PriorityNormal, 3)
+
+	assert.Equal(t, []Event{
+		{Type: EventAllow, Key: "a", Remaining: 1},
+		{Type: EventAllow, Key: "a", Remaining: 0},
+		{Type: EventDeny, Key: "a", Limit: Limit{Dur: time.Minute, Limit: 2, Global: true}, RetryAfter: time.Minute},
+	}, o.events)
+}
+
+func TestPriorityByHeader(t *testing.T) {
+	fn := PriorityByHeader("X-Priority", map[string]Priority{"batch": PriorityLow}, PriorityNormal)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	assert.Equal(t, PriorityNormal, fn(r))
+
+	r.Header.Set("X-Priority", "batch")
+	assert.Equal(t, PriorityLow, fn(r))
+}
+
+func TestNewPriorityLimiterCopiesGlobal(t *testing.T) {
+	global := []Limit{{Dur: time.Minute, Limit: 10}, {Dur: time.Hour, Limit: 100}}
+
+	l := NewPriorityLimiter(nil, global, nil)
+
+	assert.Equal(t, []Limit{{Dur: time.Minute, Limit: 10}, {Dur: time.Hour, Limit: 100}}, global)
+	assert.Equal(t, []Limit{{Dur: time.Hour, Limit: 100, Global: true}, {Dur: time.Minute, Limit: 10, Global: true}}, l.Global)
+}
+
+func TestPriorityLimiterGivesBackOnlyToWindowOfUnit(t *testing.T) {
+	now := time.Now()
+	var l *PriorityLimiter
+	limiter := &fakeLimiter{
+		LimitFunc: func(key string) bool {
+			if key == "slow" {
+				// the window resets and another request is let through while
+				// the limiter is checked
+				now = now.Add(time.Minute)
+				assert.False(t, l.LimitPriority("a", PriorityNormal))
+				return true
+			}
+			return false
+		},
+	}
+	l = newTestPriorityLimiter(limiter, 2, nil)
+	l.Clock = funcClock(func() time.Time { return now })
+
+	assert.True(t, l.LimitPriority("slow", PriorityNormal))
+	assert.Equal(t, []int{1}, l.Used(PriorityNormal))
+}
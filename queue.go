@@ -0,0 +1,151 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by Queue.Wait when MaxDepth requests are
+	// already waiting for the key
+	ErrQueueFull = errors.New("queue is full")
+	// ErrDelayTooLong is returned by Queue.Wait when the request would have
+	// to wait longer than MaxDelay
+	ErrDelayTooLong = errors.New("delay would exceed max delay")
+)
+
+// NewQueue creates a properly initialized Queue which lets requests through
+// at the rate of limit and holds them back for up to maxDelay
+func NewQueue(limit Limit, maxDelay time.Duration) *Queue {
+	return &Queue{
+		Limit:    limit,
+		MaxDelay: maxDelay,
+		Clock:    SystemClock,
+		buckets:  make(map[string]*bucket),
+	}
+}
+
+// Queue smooths bursts of requests instead of rejecting them. It is a leaky
+// bucket which lets requests of a key through evenly spaced at the rate of
+// Limit, one every Limit.Dur / Limit.Limit, and holds back the requests which
+// arrive early. Requests are only rejected when they would be held back for
+// longer than MaxDelay or when MaxDepth requests are already waiting. A
+// Global Limit queues the requests of every key together. Zero MaxDepth means
+// no cap
+type Queue struct {
+	Limit    Limit
+	MaxDelay time.Duration
+	MaxDepth int
+	Observer Observer
+	Clock    Clock
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	nextSweep time.Time
+}
+
+// bucket is the queue of a key
+type bucket struct {
+	// next is when the next request may go through
+	next  time.Time
+	depth int
+}
+
+// Wait blocks until the request of key may go through. It returns
+// ErrQueueFull or ErrDelayTooLong immediately when the request is rejected
+// and ctx.Err() when ctx is done before the request is let through, such as
+// when the client disconnects
+func (q *Queue) Wait(ctx context.Context, key string) error {
+	if q.Limit.Global {
+		key = "global"
+	}
+
+	q.mu.Lock()
+	now := q.Clock.Now()
+	q.sweep(now)
+
+	b := q.buckets[key]
+	if b == nil {
+		b = &bucket{}
+		q.buckets[key] = b
+	}
+	at := b.next
+	if at.Before(now) {
+		at = now
+	}
+	delay := at.Sub(now)
+
+	if delay > q.MaxDelay || (q.MaxDepth > 0 && b.depth >= q.MaxDepth) {
+		depth := b.depth
+		q.mu.Unlock()
+		observe(q.Observer, Event{Type: EventDeny, Key: key, Limit: q.Limit, RetryAfter: delay, Depth: depth})
+		if delay > q.MaxDelay {
+			return ErrDelayTooLong
+		}
+		return ErrQueueFull
+	}
+
+	b.next = at.Add(q.interval())
+	if delay == 0 {
+		q.mu.Unlock()
+		observe(q.Observer, Event{Type: EventAllow, Key: key})
+		return nil
+	}
+	b.depth++
+	depth := b.depth
+	q.mu.Unlock()
+	observe(q.Observer, Event{Type: EventQueue, Key: key, Delay: delay, Depth: depth})
+
+	waited := sleep(q.Clock, delay, ctx.Done())
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	b.depth--
+	if waited {
+		return nil
+	}
+	if b.next.Equal(at.Add(q.interval())) {
+		// nobody queued up behind the request so its turn is given back
+		b.next = at
+	}
+	return ctx.Err()
+}
+
+// Depth returns the number of requests waiting in the queue of key
+func (q *Queue) Depth(key string) int {
+	if q.Limit.Global {
+		key = "global"
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if b := q.buckets[key]; b != nil {
+		return b.depth
+	}
+	return 0
+}
+
+// interval returns the time between two requests of a key
+func (q *Queue) interval() time.Duration {
+	if q.Limit.Limit <= 0 {
+		return q.Limit.Dur
+	}
+	return q.Limit.Dur / time.Duration(q.Limit.Limit)
+}
+
+// sweep removes the buckets of keys which have drained. q.mu must be held
+func (q *Queue) sweep(now time.Time) {
+	if now.Before(q.nextSweep) {
+		return
+	}
+	for key, b := range q.buckets {
+		if b.depth == 0 && !b.next.After(now) {
+			delete(q.buckets, key)
+		}
+	}
+	q.nextSweep = now.Add(sweepInterval)
+}
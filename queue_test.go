@@ -0,0 +1,77 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueSpacesRequestsEvenly(t *testing.T) {
+	c := &stepClock{now: time.Now()}
+	q := NewQueue(Limit{Dur: time.Second, Limit: 2}, time.Second)
+	q.Clock = c
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, q.Wait(context.Background(), "a"))
+	}
+
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, c.slept)
+}
+
+func TestQueueRejectsWhenDelayIsTooLong(t *testing.T) {
+	now := time.Now()
+	q := NewQueue(Limit{Dur: time.Second, Limit: 2}, 100*time.Millisecond)
+	q.Clock = funcClock(func() time.Time { return now })
+
+	require.NoError(t, q.Wait(context.Background(), "a"))
+	assert.Equal(t, ErrDelayTooLong, q.Wait(context.Background(), "a"))
+	require.NoError(t, q.Wait(context.Background(), "b"), "other keys have their own queue")
+
+	now = now.Add(500 * time.Millisecond)
+	require.NoError(t, q.Wait(context.Background(), "a"))
+}
+
+func TestQueueGlobalSharesOneQueue(t *testing.T) {
+	now := time.Now()
+	q := NewQueue(Limit{Dur: time.Second, Limit: 2, Global: true}, 0)
+	q.Clock = funcClock(func() time.Time { return now })
+
+	require.NoError(t, q.Wait(context.Background(), "a"))
+	assert.Equal(t, ErrDelayTooLong, q.Wait(context.Background(), "b"))
+}
+
+func TestQueueSendsEvents(t *testing.T) {
+	o := &recordingObserver{}
+	c := &stepClock{now: time.Now()}
+	limit := Limit{Dur: time.Second, Limit: 2}
+	q := NewQueue(limit, 500*time.Millisecond)
+	q.Clock = c
+	q.Observer = o
+
+	q.Wait(context.Background(), "a")
+	q.Wait(context.Background(), "a")
+	// the step clock has moved to the turn of the second request
+	c.now = c.now.Add(-500 * time.Millisecond)
+	q.Wait(context.Background(), "a")
+
+	assert.Equal(t, []Event{
+		{Type: EventAllow, Key: "a"},
+		{Type: EventQueue, Key: "a", Delay: 500 * time.Millisecond, Depth: 1},
+		{Type: EventDeny, Key: "a", Limit: limit, RetryAfter: time.Second},
+	}, o.events)
+}
+
+func TestQueueSweepsDrainedKeys(t *testing.T) {
+	now := time.Now()
+	q := NewQueue(Limit{Dur: time.Second, Limit: 1}, 0)
+	q.Clock = funcClock(func() time.Time { return now })
+
+	q.Wait(context.Background(), "a")
+	now = now.Add(sweepInterval)
+	q.Wait(context.Background(), "b")
+
+	assert.Len(t, q.buckets, 1)
+}
@@ -0,0 +1,100 @@
+// Package ratelimitertest provides helpers for testing code which uses
+// ratelimiter
+package ratelimitertest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewFakeClock creates a FakeClock which starts at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// FakeClock is a ratelimiter.Clock which only moves when it is told to. Set
+// it as the Clock of a limiter to test time based behaviour without sleeping
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+// waiter is a channel returned by After which fires at until
+type waiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// Now returns the time of the clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After returns a channel which receives the time once the clock has been
+// advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{until: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Sleep blocks until the clock has been advanced by d
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Advance moves the clock forward by d and fires every channel returned by
+// After whose time has come
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to now and fires every channel returned by After whose
+// time has come
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+	sort.Slice(c.waiters, func(i, j int) bool {
+		return c.waiters[i].until.Before(c.waiters[j].until)
+	})
+	fired := 0
+	for _, w := range c.waiters {
+		if w.until.After(now) {
+			break
+		}
+		w.ch <- now
+		fired++
+	}
+	c.waiters = c.waiters[fired:]
+}
+
+// Waiters returns the number of channels returned by After which haven't
+// fired yet. Tests use it to wait until a goroutine is blocked on the clock
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+// BlockUntil waits until there are at least n channels returned by After
+// which haven't fired yet
+func (c *FakeClock) BlockUntil(n int) {
+	for c.Waiters() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimitertest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blockloop/ratelimiter"
+	"github.com/blockloop/ratelimiter/ratelimitertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+var _ ratelimiter.Clock = &ratelimitertest.FakeClock{}
+
+func TestFakeClockAfterFiresWhenAdvanced(t *testing.T) {
+	c := ratelimitertest.NewFakeClock(start)
+	ch := c.After(time.Minute)
+
+	c.Advance(59 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("fired too early")
+	default:
+	}
+
+	c.Advance(time.Second)
+	assert.Equal(t, start.Add(time.Minute), <-ch)
+	assert.Equal(t, 0, c.Waiters())
+}
+
+func TestFakeClockAfterFiresImmediatelyWithoutDuration(t *testing.T) {
+	c := ratelimitertest.NewFakeClock(start)
+
+	assert.Equal(t, start, <-c.After(0))
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	c := ratelimitertest.NewFakeClock(start)
+	done := make(chan struct{})
+	go func() {
+		c.Sleep(time.Second)
+		close(done)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Second)
+	<-done
+	assert.Equal(t, start.Add(time.Second), c.Now())
+}
+
+func TestFakeClockExpiresMemoryLimiterWindows(t *testing.T) {
+	c := ratelimitertest.NewFakeClock(start)
+	l := ratelimiter.NewMemoryLimiter(ratelimiter.MustParseLimits([]string{"2/1m"}))
+	l.Clock = c
+
+	assert.False(t, l.Limit("a"))
+	assert.False(t, l.Limit("a"))
+	assert.True(t, l.Limit("a"))
+
+	c.Advance(59 * time.Second)
+	assert.True(t, l.Limit("a"))
+
+	c.Advance(time.Second)
+	assert.False(t, l.Limit("a"))
+}
+
+func TestFakeClockDrivesWait(t *testing.T) {
+	c := ratelimitertest.NewFakeClock(start)
+	l := ratelimiter.NewMemoryLimiter(ratelimiter.MustParseLimits([]string{"1/1h"}))
+	l.Clock = c
+	l.Limit("a")
+
+	done := make(chan error)
+	go func() {
+		done <- l.Wait(context.Background(), "a")
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Hour)
+	require.NoError(t, <-done)
+}
+
+func TestFakeClockExpiresPenaltyBans(t *testing.T) {
+	c := ratelimitertest.NewFakeClock(start)
+	s := ratelimiter.NewMemoryPenaltyStore()
+	s.Clock = c
+
+	require.NoError(t, s.Ban("a", time.Minute))
+	c.Advance(20 * time.Second)
+
+	banned, err := s.Banned("a")
+	require.NoError(t, err)
+	assert.Equal(t, 40*time.Second, banned)
+
+	c.Advance(40 * time.Second)
+	banned, err = s.Banned("a")
+	require.NoError(t, err)
+	assert.Zero(t, banned)
+}
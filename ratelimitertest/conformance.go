@@ -0,0 +1,164 @@
+package ratelimitertest
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blockloop/ratelimiter"
+)
+
+// Factory creates a fresh Limiter with limits for a single test. advance
+// moves the time seen by the limiter forward by d, for example with
+// FakeClock.Advance or miniredis FastForward. Tests of window expiry are
+// skipped when advance is nil
+type Factory func(t *testing.T, limits []ratelimiter.Limit) (l ratelimiter.Limiter, advance func(d time.Duration))
+
+// Option changes how Run tests a Limiter
+type Option func(*suite)
+
+type suite struct {
+	concurrent bool
+}
+
+// SkipConcurrent skips the test of concurrent checks. It is meant for
+// backends tested against a server which doesn't run scripts atomically,
+// such as miniredis
+func SkipConcurrent() Option {
+	return func(s *suite) {
+		s.concurrent = false
+	}
+}
+
+// Run runs the conformance suite against the Limiter created by factory.
+// Every backend is expected to behave the same way for per key and Global
+// limits, chained limits, window expiry and concurrent checks
+//
+// Example:
+//    func TestConformance(t *testing.T) {
+//        ratelimitertest.Run(t, func(t *testing.T, limits []ratelimiter.Limit) (ratelimiter.Limiter, func(time.Duration)) {
+//            clock := ratelimitertest.NewFakeClock(time.Now())
+//            l := NewMyLimiter(limits)
+//            l.Clock = clock
+//            return l, clock.Advance
+//        })
+//    }
+func Run(t *testing.T, factory Factory, opts ...Option) {
+	s := &suite{concurrent: true}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	t.Run("AllowsUpToLimit", func(t *testing.T) {
+		l, _ := factory(t, limits("3/1m"))
+
+		expect(t, l, "a", false, false, false, true, true)
+	})
+
+	t.Run("PerKey", func(t *testing.T) {
+		l, _ := factory(t, limits("1/1m"))
+
+		expect(t, l, "a", false, true)
+		expect(t, l, "b", false, true)
+	})
+
+	t.Run("Global", func(t *testing.T) {
+		l, _ := factory(t, limits("2/1m/g"))
+
+		expect(t, l, "a", false)
+		expect(t, l, "b", false)
+		expect(t, l, "c", true)
+		expect(t, l, "a", true)
+	})
+
+	t.Run("GlobalAndPerKey", func(t *testing.T) {
+		l, _ := factory(t, limits("2/1m/g", "1/1m"))
+
+		expect(t, l, "a", false, true)
+		expect(t, l, "b", false)
+		expect(t, l, "c", true)
+	})
+
+	t.Run("ChainedLimitsInAnyOrder", func(t *testing.T) {
+		// the tightest limit wins regardless of the order limits are given
+		for _, order := range [][]string{{"5/1s", "2/1m"}, {"2/1m", "5/1s"}} {
+			l, advance := factory(t, limits(order...))
+
+			expect(t, l, "a", false, false, true)
+			if advance != nil {
+				// the minute window still denies after the second expires
+				advance(time.Second)
+				expect(t, l, "a", true)
+			}
+		}
+	})
+
+	t.Run("WindowExpiry", func(t *testing.T) {
+		l, advance := factory(t, limits("1/1m"))
+		if advance == nil {
+			t.Skip("factory can't advance time")
+		}
+
+		expect(t, l, "a", false, true)
+		advance(30 * time.Second)
+		expect(t, l, "a", true)
+		advance(30 * time.Second)
+		expect(t, l, "a", false, true)
+	})
+
+	t.Run("ShortWindowExpiresFirst", func(t *testing.T) {
+		l, advance := factory(t, limits("1/1s", "3/1h"))
+		if advance == nil {
+			t.Skip("factory can't advance time")
+		}
+
+		expect(t, l, "a", false)
+		for i := 0; i < 2; i++ {
+			advance(time.Second)
+			expect(t, l, "a", false)
+		}
+		advance(time.Second)
+		expect(t, l, "a", true)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		if !s.concurrent {
+			t.Skip("concurrent checks are skipped")
+		}
+		const limit, workers = 50, 100
+		l, _ := factory(t, limits(strconv.Itoa(limit)+"/1m"))
+
+		var allowed int64
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !l.Limit("a") {
+					atomic.AddInt64(&allowed, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if allowed != limit {
+			t.Errorf("allowed %d of %d concurrent requests, want %d", allowed, workers, limit)
+		}
+	})
+}
+
+func limits(raw ...string) []ratelimiter.Limit {
+	return ratelimiter.MustParseLimits(raw)
+}
+
+// expect checks key once for every result in want
+func expect(t *testing.T, l ratelimiter.Limiter, key string, want ...bool) {
+	t.Helper()
+	for i, w := range want {
+		if got := l.Limit(key); got != w {
+			t.Fatalf("request %d of %q: limited = %t, want %t", i+1, key, got, w)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package ratelimitertest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/blockloop/ratelimiter"
+	"github.com/blockloop/ratelimiter/ratelimitertest"
+	"github.com/stretchr/testify/require"
+)
+
+// redisServer starts a miniredis server which the factories of a test share.
+// fresh empties it for every subtest
+func redisServer(t *testing.T) (srv *miniredis.Miniredis, fresh func() *miniredis.Miniredis) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	return srv, func() *miniredis.Miniredis {
+		srv.FlushAll()
+		return srv
+	}
+}
+
+func TestMemoryLimiterConformance(t *testing.T) {
+	ratelimitertest.Run(t, func(t *testing.T, limits []ratelimiter.Limit) (ratelimiter.Limiter, func(time.Duration)) {
+		clock := ratelimitertest.NewFakeClock(start)
+		l := ratelimiter.NewMemoryLimiter(limits)
+		l.Clock = clock
+		return l, clock.Advance
+	})
+}
+
+func TestRedisLimiterConformance(t *testing.T) {
+	server, fresh := redisServer(t)
+	defer server.Close()
+
+	ratelimitertest.Run(t, func(t *testing.T, limits []ratelimiter.Limit) (ratelimiter.Limiter, func(time.Duration)) {
+		srv := fresh()
+		return ratelimiter.NewRedisLimiter(&ratelimitertest.FakePool{Addr: srv.Addr()}, limits), srv.FastForward
+	}, ratelimitertest.SkipConcurrent())
+}
+
+func TestLeaseLimiterConformance(t *testing.T) {
+	server, fresh := redisServer(t)
+	defer server.Close()
+
+	ratelimitertest.Run(t, func(t *testing.T, limits []ratelimiter.Limit) (ratelimiter.Limiter, func(time.Duration)) {
+		srv := fresh()
+		clock := ratelimitertest.NewFakeClock(start)
+		l := ratelimiter.NewLeaseLimiter(&ratelimitertest.FakePool{Addr: srv.Addr()}, limits)
+		l.Clock = clock
+		return l, func(d time.Duration) {
+			clock.Advance(d)
+			srv.FastForward(d)
+		}
+	}, ratelimitertest.SkipConcurrent())
+}
+
+func TestBreakerLimiterConformance(t *testing.T) {
+	server, fresh := redisServer(t)
+	defer server.Close()
+
+	ratelimitertest.Run(t, func(t *testing.T, limits []ratelimiter.Limit) (ratelimiter.Limiter, func(time.Duration)) {
+		srv := fresh()
+		primary := ratelimiter.NewRedisLimiter(&ratelimitertest.FakePool{Addr: srv.Addr()}, limits)
+		return ratelimiter.NewBreakerLimiter(primary, 1), srv.FastForward
+	}, ratelimitertest.SkipConcurrent())
+}
+
+func TestAdaptiveLimiterConformance(t *testing.T) {
+	ratelimitertest.Run(t, func(t *testing.T, limits []ratelimiter.Limit) (ratelimiter.Limiter, func(time.Duration)) {
+		clock := ratelimitertest.NewFakeClock(start)
+		l := ratelimiter.NewAdaptiveLimiter(limits)
+		l.Clock = clock
+		return l, clock.Advance
+	})
+}
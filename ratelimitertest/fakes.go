@@ -0,0 +1,77 @@
+package ratelimitertest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrDeadPool is returned by every command on a connection from DeadPool
+var ErrDeadPool = errors.New("dead pool")
+
+// FakeLimiter is a ratelimiter.Limiter which answers with LimitFunc and
+// remembers the keys it was asked about. Without LimitFunc it never limits
+type FakeLimiter struct {
+	LimitFunc func(key string) bool
+
+	mu   sync.Mutex
+	keys []string
+}
+
+// Limit records key and returns the result of LimitFunc
+func (f *FakeLimiter) Limit(key string) bool {
+	f.mu.Lock()
+	f.keys = append(f.keys, key)
+	f.mu.Unlock()
+
+	if f.LimitFunc != nil {
+		return f.LimitFunc(key)
+	}
+	return false
+}
+
+// Keys returns the keys passed to Limit in order
+func (f *FakeLimiter) Keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.keys...)
+}
+
+// FakePool is a Redis pool which dials Addr for every connection, such as
+// the address of a miniredis server. Connections which fail to dial return
+// the error from every command
+type FakePool struct {
+	Addr string
+}
+
+// Get dials a new connection to Addr
+func (p *FakePool) Get() redis.Conn {
+	con, err := redis.DialURL("redis://" + p.Addr)
+	if err != nil {
+		return errorConn{err: err}
+	}
+	return con
+}
+
+// DeadPool is a Redis pool whose connections fail every command with
+// ErrDeadPool. It is used to test how limiters handle Redis outages
+type DeadPool struct{}
+
+// Get returns a connection which always fails
+func (DeadPool) Get() redis.Conn {
+	return errorConn{err: ErrDeadPool}
+}
+
+// errorConn is a redis.Conn which fails every command with err
+type errorConn struct {
+	err error
+}
+
+func (c errorConn) Close() error                                   { return nil }
+func (c errorConn) Err() error                                     { return c.err }
+func (c errorConn) Do(string, ...interface{}) (interface{}, error) { return nil, c.err }
+func (c errorConn) Send(string, ...interface{}) error              { return c.err }
+func (c errorConn) Flush() error                                   { return c.err }
+func (c errorConn) Receive() (interface{}, error)                  { return nil, c.err }
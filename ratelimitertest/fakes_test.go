@@ -0,0 +1,60 @@
+package ratelimitertest_test
+
+import (
+	"testing"
+
+	"github.com/blockloop/ratelimiter"
+	"github.com/blockloop/ratelimiter/ratelimitertest"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeLimiterRecordsKeys(t *testing.T) {
+	f := &ratelimitertest.FakeLimiter{
+		LimitFunc: func(key string) bool {
+			return key == "b"
+		},
+	}
+
+	assert.False(t, f.Limit("a"))
+	assert.True(t, f.Limit("b"))
+	assert.Equal(t, []string{"a", "b"}, f.Keys())
+}
+
+func TestFakeLimiterAllowsWithoutLimitFunc(t *testing.T) {
+	assert.False(t, (&ratelimitertest.FakeLimiter{}).Limit("a"))
+}
+
+func TestFakePoolDialsAddr(t *testing.T) {
+	srv, _ := redisServer(t)
+	defer srv.Close()
+
+	con := (&ratelimitertest.FakePool{Addr: srv.Addr()}).Get()
+	defer con.Close()
+
+	_, err := con.Do("SET", "a", "1")
+	require.NoError(t, err)
+	assert.True(t, srv.Exists("a"))
+}
+
+func TestFakePoolReturnsDialErrors(t *testing.T) {
+	con := (&ratelimitertest.FakePool{Addr: "127.0.0.1:1"}).Get()
+
+	_, err := con.Do("PING")
+	assert.Error(t, err)
+}
+
+func TestDeadPoolFailsEveryCommand(t *testing.T) {
+	con := ratelimitertest.DeadPool{}.Get()
+	_, err := redis.String(con.Do("PING"))
+	assert.Equal(t, ratelimitertest.ErrDeadPool, err)
+
+	l := ratelimiter.NewRedisLimiter(ratelimitertest.DeadPool{}, ratelimiter.MustParseLimits([]string{"1/1m"}))
+	l.LimitOnError = false
+	var errs int
+	l.OnError = func(string, error) { errs++ }
+
+	assert.False(t, l.Limit("a"))
+	assert.Equal(t, 1, errs)
+}
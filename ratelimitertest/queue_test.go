@@ -0,0 +1,72 @@
+package ratelimitertest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blockloop/ratelimiter"
+	"github.com/blockloop/ratelimiter/ratelimitertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueHoldsRequestsUntilTheirTurn(t *testing.T) {
+	c := ratelimitertest.NewFakeClock(start)
+	q := ratelimiter.NewQueue(ratelimiter.Limit{Dur: time.Second, Limit: 1}, time.Minute)
+	q.Clock = c
+	q.MaxDepth = 1
+
+	require.NoError(t, q.Wait(context.Background(), "a"))
+
+	done := make(chan error)
+	go func() {
+		done <- q.Wait(context.Background(), "a")
+	}()
+	c.BlockUntil(1)
+	assert.Equal(t, 1, q.Depth("a"))
+	assert.Equal(t, ratelimiter.ErrQueueFull, q.Wait(context.Background(), "a"))
+
+	c.Advance(time.Second)
+	require.NoError(t, <-done)
+	assert.Equal(t, 0, q.Depth("a"))
+}
+
+func TestQueueGivesBackTheTurnOfCancelledRequests(t *testing.T) {
+	c := ratelimitertest.NewFakeClock(start)
+	o := &recorder{}
+	q := ratelimiter.NewQueue(ratelimiter.Limit{Dur: time.Second, Limit: 1}, time.Minute)
+	q.Clock = c
+	q.Observer = o
+
+	require.NoError(t, q.Wait(context.Background(), "a"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- q.Wait(ctx, "a")
+	}()
+	c.BlockUntil(1)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, 0, q.Depth("a"))
+
+	go func() {
+		done <- q.Wait(context.Background(), "a")
+	}()
+	// the timer of the cancelled request is still pending
+	c.BlockUntil(2)
+	c.Advance(time.Second)
+	require.NoError(t, <-done)
+
+	// the next request waits the same second the cancelled request did
+	assert.Equal(t, o.events[1], o.events[2])
+}
+
+type recorder struct {
+	events []ratelimiter.Event
+}
+
+func (r *recorder) Observe(e ratelimiter.Event) {
+	r.events = append(r.events, e)
+}
@@ -0,0 +1,207 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var acquireSlot = redis.NewScript(2, `
+local perKey = tonumber(ARGV[4])
+local global = tonumber(ARGV[5])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", ARGV[1])
+if perKey > 0 and redis.call("ZCARD", KEYS[1]) >= perKey then
+    return 0
+end
+if global > 0 and redis.call("ZCARD", KEYS[2]) >= global then
+    return 0
+end
+if perKey > 0 then
+    redis.call("ZADD", KEYS[1], ARGV[2], ARGV[3])
+    redis.call("PEXPIRE", KEYS[1], ARGV[6])
+end
+if global > 0 then
+    redis.call("ZADD", KEYS[2], ARGV[2], ARGV[3])
+    redis.call("PEXPIRE", KEYS[2], ARGV[6])
+end
+return 1`)
+
+// DefaultSlotLease is how long a RedisConcurrencyLimiter leases a slot when
+// LeaseDuration isn't set
+const DefaultSlotLease = time.Minute
+
+// NewRedisConcurrencyLimiter creates a properly initialized
+// RedisConcurrencyLimiter which allows perKey requests in flight for every
+// key and global requests in flight for all keys together. Zero means no cap.
+// Slots are leased for DefaultSlotLease
+func NewRedisConcurrencyLimiter(pool redisPool, perKey, global int) *RedisConcurrencyLimiter {
+	return &RedisConcurrencyLimiter{
+		Pool:          pool,
+		PerKey:        perKey,
+		Global:        global,
+		LeaseDuration: DefaultSlotLease,
+		LimitOnError:  true,
+		Clock:         SystemClock,
+	}
+}
+
+// RedisConcurrencyLimiter is a ConcurrencyLimiter which shares the requests in
+// flight between processes using Redis as a backend. Every slot is a lease
+// which expires after LeaseDuration so slots of crashed instances are freed.
+// Leases are renewed every LeaseDuration / 2 until the slot is released, so
+// slow requests keep counting for as long as they run. Lease durations under
+// a millisecond, which Redis can't expire, are replaced by DefaultSlotLease.
+//
+// Lease expiry is measured by Clock, so the clocks of all instances should
+// be in sync
+type RedisConcurrencyLimiter struct {
+	Pool          redisPool
+	PerKey        int
+	Global        int
+	LeaseDuration time.Duration
+	LimitOnError  bool
+	OnError       func(key string, err error)
+	Observer      Observer
+	Clock         Clock
+}
+
+// Acquire takes a slot for key. See ConcurrencyLimiter. Errors are reported
+// to OnError and the slot is refused when LimitOnError is true
+func (l *RedisConcurrencyLimiter) Acquire(key string) (func(), bool) {
+	token, err := newReservationToken()
+	if err != nil {
+		return l.error(key, fmt.Errorf("%s: %s", "failed to create token", err))
+	}
+
+	con := l.Pool.Get()
+	defer con.Close()
+
+	now := l.clock().Now()
+	lease := l.leaseDuration()
+	ok, err := redis.Bool(acquireSlot.Do(con,
+		inFlightKey(key), globalInFlightKey,
+		toMillis(now), toMillis(now.Add(lease)), token,
+		l.PerKey, l.Global, lease.Nanoseconds()/int64(time.Millisecond),
+	))
+	if err != nil {
+		return l.error(key, fmt.Errorf("%s: %s", "failed to acquire slot", err))
+	}
+	if !ok {
+		observe(l.Observer, Event{Type: EventDeny, Key: key})
+		return func() {}, false
+	}
+	observe(l.Observer, Event{Type: EventAllow, Key: key})
+
+	stop := make(chan struct{})
+	go l.renew(key, token, stop)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			l.release(key, token)
+		})
+	}, true
+}
+
+// renew extends the lease of token every LeaseDuration / 2 until stop is
+// closed
+func (l *RedisConcurrencyLimiter) renew(key, token string, stop chan struct{}) {
+	for {
+		if !sleep(l.clock(), l.leaseDuration()/2, stop) {
+			return
+		}
+
+		if err := l.extend(key, token); err != nil {
+			l.reportError(key, err)
+		}
+	}
+}
+
+// extend moves the expiry of the lease of token to LeaseDuration from now
+func (l *RedisConcurrencyLimiter) extend(key, token string) error {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	lease := l.leaseDuration()
+	expires := toMillis(l.clock().Now().Add(lease))
+	ttl := lease.Nanoseconds() / int64(time.Millisecond)
+	con.Send("MULTI")
+	for _, k := range []string{inFlightKey(key), globalInFlightKey} {
+		// XX only updates leases which haven't been released or expired
+		con.Send("ZADD", k, "XX", expires, token)
+		con.Send("PEXPIRE", k, ttl)
+	}
+	if _, err := con.Do("EXEC"); err != nil {
+		return fmt.Errorf("%s: %s", "failed to renew slot", err)
+	}
+	return nil
+}
+
+// InFlight returns the number of requests in flight for key
+func (l *RedisConcurrencyLimiter) InFlight(key string) (int, error) {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	n, err := redis.Int(con.Do("ZCOUNT", inFlightKey(key), toMillis(l.clock().Now())+1, "+inf"))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", "failed to count slots", err)
+	}
+	return n, nil
+}
+
+func (l *RedisConcurrencyLimiter) release(key, token string) {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	con.Send("MULTI")
+	con.Send("ZREM", inFlightKey(key), token)
+	con.Send("ZREM", globalInFlightKey, token)
+	if _, err := con.Do("EXEC"); err != nil {
+		// the lease frees the slot when it expires
+		l.reportError(key, fmt.Errorf("%s: %s", "failed to release slot", err))
+	}
+}
+
+func (l *RedisConcurrencyLimiter) clock() Clock {
+	return clockOrSystem(l.Clock)
+}
+
+// leaseDuration returns LeaseDuration or DefaultSlotLease when it is too short
+// for Redis to expire, such as when it isn't set
+func (l *RedisConcurrencyLimiter) leaseDuration() time.Duration {
+	if l.LeaseDuration < time.Millisecond {
+		return DefaultSlotLease
+	}
+	return l.LeaseDuration
+}
+
+func (l *RedisConcurrencyLimiter) error(key string, err error) (func(), bool) {
+	l.reportError(key, err)
+	return func() {}, !l.LimitOnError
+}
+
+func (l *RedisConcurrencyLimiter) reportError(key string, err error) {
+	if l.OnError != nil {
+		l.OnError(key, err)
+	}
+	observe(l.Observer, Event{Type: EventError, Key: key, Err: err})
+}
+
+// globalInFlightKey is the key of the sorted set which holds the slots of all
+// keys together. It is outside of the namespace of inFlightKey so it can't
+// collide with a key
+const globalInFlightKey = "inflight-global"
+
+// inFlightKey returns the key of the sorted set which holds the slots of key
+func inFlightKey(key string) string {
+	return "inflight:" + key
+}
+
+// toMillis returns t as milliseconds since the epoch
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
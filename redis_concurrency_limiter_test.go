@@ -0,0 +1,175 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisConcurrencyLimiterSharesSlots(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	a := NewRedisConcurrencyLimiter(&fakePool{addr: srv.Addr()}, 2, 0)
+	b := NewRedisConcurrencyLimiter(&fakePool{addr: srv.Addr()}, 2, 0)
+
+	release, ok := a.Acquire("x")
+	assert.True(t, ok)
+	_, ok = b.Acquire("x")
+	assert.True(t, ok)
+	_, ok = a.Acquire("x")
+	assert.False(t, ok)
+	_, ok = b.Acquire("y")
+	assert.True(t, ok, "other keys have their own slots")
+
+	release()
+	release()
+	n, err := b.InFlight("x")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, ok = b.Acquire("x")
+	assert.True(t, ok)
+}
+
+func TestRedisConcurrencyLimiterCapsGlobal(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := NewRedisConcurrencyLimiter(&fakePool{addr: srv.Addr()}, 0, 2)
+
+	release, _ := l.Acquire("a")
+	l.Acquire("b")
+	_, ok := l.Acquire("c")
+	assert.False(t, ok)
+
+	release()
+	_, ok = l.Acquire("c")
+	assert.True(t, ok)
+}
+
+func TestRedisConcurrencyLimiterExpiresLeases(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	now := time.Now()
+	l := NewRedisConcurrencyLimiter(&fakePool{addr: srv.Addr()}, 1, 1)
+	l.LeaseDuration = time.Minute
+	l.Clock = funcClock(func() time.Time { return now })
+
+	// a crashed instance never releases its slot
+	_, ok := l.Acquire("a")
+	assert.True(t, ok)
+	_, ok = l.Acquire("a")
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = l.Acquire("a")
+	assert.True(t, ok)
+	assert.True(t, srv.Exists(inFlightKey("a")))
+}
+
+func TestRedisConcurrencyLimiterRespectsLimitOnError(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	var errKey string
+	l := NewRedisConcurrencyLimiter(&deadPool{addr: srv.Addr()}, 1, 0)
+	l.OnError = func(key string, err error) {
+		assert.Error(t, err)
+		errKey = key
+	}
+
+	_, ok := l.Acquire("a")
+	assert.False(t, ok)
+	assert.Equal(t, "a", errKey)
+
+	l.LimitOnError = false
+	release, ok := l.Acquire("a")
+	assert.True(t, ok)
+	release()
+}
+
+func TestRedisConcurrencyLimiterRenewsLeasesWhileHeld(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := NewRedisConcurrencyLimiter(&fakePool{addr: srv.Addr()}, 1, 0)
+	l.LeaseDuration = 100 * time.Millisecond
+
+	release, ok := l.Acquire("a")
+	require.True(t, ok)
+	time.Sleep(3 * l.LeaseDuration)
+
+	n, err := l.InFlight("a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, ok = l.Acquire("a")
+	assert.False(t, ok)
+
+	release()
+	release, ok = l.Acquire("a")
+	assert.True(t, ok)
+	release()
+}
+
+func TestRedisConcurrencyLimiterGlobalDoesNotCollideWithKeys(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := NewRedisConcurrencyLimiter(&fakePool{addr: srv.Addr()}, 1, 2)
+
+	_, ok := l.Acquire("global")
+	assert.True(t, ok)
+	_, ok = l.Acquire("a")
+	assert.True(t, ok)
+}
+
+func TestRedisConcurrencyLimiterWithoutConstructor(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := &RedisConcurrencyLimiter{
+		Pool:          &fakePool{addr: srv.Addr()},
+		PerKey:        1,
+		LeaseDuration: time.Minute,
+	}
+
+	release, ok := l.Acquire("x")
+	assert.True(t, ok)
+	n, err := l.InFlight("x")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	release()
+}
+
+func TestRedisConcurrencyLimiterWithoutLeaseDuration(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := &RedisConcurrencyLimiter{Pool: &fakePool{addr: srv.Addr()}, PerKey: 1}
+
+	release, ok := l.Acquire("x")
+	assert.True(t, ok)
+	defer release()
+
+	commands := srv.CommandCount()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, commands, srv.CommandCount())
+
+	n, err := l.InFlight("x")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, DefaultSlotLease, srv.TTL(inFlightKey("x")))
+}
@@ -0,0 +1,189 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrPathMismatch is returned when a key doesn't have a part for every level
+// of a HierarchicalLimiter
+var ErrPathMismatch = errors.New("key doesn't match the levels")
+
+var hierarchy = redis.NewScript(-1, `
+for i = 1, #KEYS do
+    if redis.call("LLEN", KEYS[i]) >= tonumber(ARGV[i * 2]) then
+        return {1, i, redis.call("PTTL", KEYS[i])}
+    end
+end
+local remaining = -1
+for i = 1, #KEYS do
+    local k = KEYS[i]
+    if redis.call("EXISTS", k) == 1 then
+        redis.call("RPUSHX", k, ARGV[1])
+    else
+        redis.call("RPUSH", k, ARGV[1])
+        redis.call("PEXPIRE", k, ARGV[i * 2 + 1])
+    end
+    local left = tonumber(ARGV[i * 2]) - redis.call("LLEN", k)
+    if remaining < 0 or left < remaining then
+        remaining = left
+    end
+end
+return {0, 0, remaining}`)
+
+// Level is a level of a HierarchicalLimiter such as tenant, user or IP
+// address. Name must be unique within the limiter. Key derives the part of
+// the key of a request for the level and is only used by
+// HierarchicalLimiter.KeyFunc
+type Level struct {
+	Name   string
+	Limits []Limit
+	Key    KeyFunc
+}
+
+// JoinPath joins the parts of a key of a HierarchicalLimiter, one for every
+// level starting with the top level
+func JoinPath(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, p := range parts {
+		escaped[i] = url.PathEscape(p)
+	}
+	return strings.Join(escaped, "/")
+}
+
+// splitPath splits a key created with JoinPath into n parts
+func splitPath(key string, n int) ([]string, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != n {
+		return nil, ErrPathMismatch
+	}
+	for i, p := range parts {
+		part, err := url.PathUnescape(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", "failed to unescape key", err)
+		}
+		parts[i] = part
+	}
+	return parts, nil
+}
+
+// NewHierarchicalLimiter creates a properly initialized HierarchicalLimiter
+// with levels ordered from the top level down
+func NewHierarchicalLimiter(pool redisPool, levels []Level) *HierarchicalLimiter {
+	for _, level := range levels {
+		sort.Sort(byDuration(level.Limits))
+	}
+
+	return &HierarchicalLimiter{
+		Pool:         pool,
+		Levels:       levels,
+		LimitOnError: true,
+	}
+}
+
+// HierarchicalLimiter is a rate limit with nested quotas, such as a tenant
+// which may do 10000/1m in total, each user of the tenant 500/1m and each IP
+// address of the user 50/1m. A request is checked against the limits of every
+// level and only consumes a unit from them when all allow it, atomically in a
+// single Redis script.
+//
+// The key of a request has a part for every level and is created with
+// JoinPath or KeyFunc. Each level counts the path down to it, so users with
+// the same name in different tenants don't share their counters. Global
+// limits of a level are shared by every key of that level. Counters are kept
+// in the hierarchy namespace, apart from the counters of RedisLimiter
+type HierarchicalLimiter struct {
+	Pool         redisPool
+	Levels       []Level
+	LimitOnError bool
+	OnError      func(key string, err error)
+	Collector    Collector
+	Observer     Observer
+}
+
+// KeyFunc returns a KeyFunc which joins the keys returned by Key of every
+// level. It is meant to be used with WithKeyFunc
+func (h *HierarchicalLimiter) KeyFunc() KeyFunc {
+	return func(r *http.Request) string {
+		parts := make([]string, len(h.Levels))
+		for i, level := range h.Levels {
+			parts[i] = level.Key(r)
+		}
+		return JoinPath(parts...)
+	}
+}
+
+// Limit checks the key of a request created with JoinPath against every
+// level. It returns true if the key should be ratelimited and false
+// otherwise. Errors are handled with LimitOnError and OnError
+func (h *HierarchicalLimiter) Limit(key string) bool {
+	limited, _, err := h.LimitRetry(key)
+	if err != nil {
+		if h.OnError != nil {
+			h.OnError(key, err)
+		}
+		return h.LimitOnError
+	}
+	return limited
+}
+
+// LimitRetry checks key the same way as Limit but also returns how long the
+// key must wait before it will be allowed again. Errors are returned to the
+// caller instead of being handled with LimitOnError and OnError
+func (h *HierarchicalLimiter) LimitRetry(key string) (bool, time.Duration, error) {
+	parts, err := splitPath(key, len(h.Levels))
+	if err != nil {
+		observe(h.Observer, Event{Type: EventError, Key: key, Err: err})
+		return false, 0, err
+	}
+
+	var limits []Limit
+	var keys []interface{}
+	for i, level := range h.Levels {
+		path := JoinPath(parts[:i+1]...)
+		for _, limit := range level.Limits {
+			limits = append(limits, limit)
+			keys = append(keys, counterKey("hierarchy:"+level.Name, path, limit))
+		}
+	}
+	if len(limits) == 0 {
+		return false, 0, nil
+	}
+
+	args := redis.Args{len(keys)}.Add(keys...).Add("1")
+	for _, limit := range limits {
+		args = args.Add(limit.Limit, limit.Dur.Nanoseconds()/int64(time.Millisecond))
+	}
+
+	con := h.Pool.Get()
+	defer con.Close()
+
+	start := time.Now()
+	res, err := redis.Ints(hierarchy.Do(con, args...))
+	collectLatency(h.Collector, "redis", time.Since(start))
+	if err != nil {
+		err := fmt.Errorf("%s: %s", "failed to execute script", err)
+		observe(h.Observer, Event{Type: EventError, Key: key, Err: err})
+		return false, 0, err
+	}
+
+	if res[0] != 0 {
+		limit := limits[res[1]-1]
+		retry := time.Duration(res[2]) * time.Millisecond
+		collectDecision(h.Collector, limit, DecisionDenied)
+		observe(h.Observer, Event{Type: EventDeny, Key: key, Limit: limit, RetryAfter: retry})
+		return true, retry, nil
+	}
+	for _, limit := range limits {
+		collectDecision(h.Collector, limit, DecisionAllowed)
+	}
+	observe(h.Observer, Event{Type: EventAllow, Key: key, Remaining: res[2]})
+	return false, 0, nil
+}
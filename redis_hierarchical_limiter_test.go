@@ -0,0 +1,141 @@
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHierarchy(t *testing.T) (*HierarchicalLimiter, *miniredis.Miniredis) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+
+	return NewHierarchicalLimiter(&fakePool{addr: srv.Addr()}, []Level{
+		{Name: "tenant", Limits: []Limit{{Dur: time.Minute, Limit: 4}}},
+		{Name: "user", Limits: []Limit{{Dur: time.Minute, Limit: 3}}},
+		{Name: "ip", Limits: []Limit{{Dur: time.Minute, Limit: 2}}},
+	}), srv
+}
+
+func TestHierarchicalLimiterChecksEveryLevel(t *testing.T) {
+	h, srv := newTestHierarchy(t)
+	defer srv.Close()
+
+	assert.False(t, h.Limit(JoinPath("acme", "bob", "1.1.1.1")))
+	assert.False(t, h.Limit(JoinPath("acme", "bob", "1.1.1.1")))
+	assert.True(t, h.Limit(JoinPath("acme", "bob", "1.1.1.1")), "ip")
+	assert.False(t, h.Limit(JoinPath("acme", "bob", "2.2.2.2")))
+	assert.True(t, h.Limit(JoinPath("acme", "bob", "3.3.3.3")), "user")
+	assert.False(t, h.Limit(JoinPath("acme", "alice", "1.1.1.1")))
+	assert.True(t, h.Limit(JoinPath("acme", "carol", "4.4.4.4")), "tenant")
+
+	assert.False(t, h.Limit(JoinPath("initech", "bob", "1.1.1.1")), "tenants have their own users")
+}
+
+func TestHierarchicalLimiterConsumesAtomically(t *testing.T) {
+	h, srv := newTestHierarchy(t)
+	defer srv.Close()
+
+	h.Limit(JoinPath("acme", "bob", "1.1.1.1"))
+	h.Limit(JoinPath("acme", "bob", "1.1.1.1"))
+	h.Limit(JoinPath("acme", "bob", "1.1.1.1"))
+
+	// the request denied by the ip level consumed nothing
+	tenant, err := srv.List("hierarchy:tenant:acme:60")
+	require.NoError(t, err)
+	assert.Len(t, tenant, 2)
+	user, err := srv.List("hierarchy:user:acme/bob:60")
+	require.NoError(t, err)
+	assert.Len(t, user, 2)
+}
+
+func TestHierarchicalLimiterDoesNotShareCountersWithRedisLimiter(t *testing.T) {
+	h, srv := newTestHierarchy(t)
+	defer srv.Close()
+
+	l := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 100}})
+	for i := 0; i < 10; i++ {
+		l.Limit("tenant:acme")
+	}
+
+	assert.False(t, h.Limit(JoinPath("acme", "bob", "1.1.1.1")))
+}
+
+func TestHierarchicalLimiterReturnsRetry(t *testing.T) {
+	h, srv := newTestHierarchy(t)
+	defer srv.Close()
+
+	key := JoinPath("acme", "bob", "1.1.1.1")
+
+	h.Limit(key)
+	h.Limit(key)
+	limited, retry, err := h.LimitRetry(key)
+
+	require.NoError(t, err)
+	assert.True(t, limited)
+	assert.Equal(t, time.Minute, retry)
+}
+
+func TestHierarchicalLimiterRejectsKeysWithoutEveryLevel(t *testing.T) {
+	h, srv := newTestHierarchy(t)
+	defer srv.Close()
+
+	var errKey string
+	h.OnError = func(key string, err error) {
+		assert.Equal(t, ErrPathMismatch, err)
+		errKey = key
+	}
+
+	assert.True(t, h.Limit("acme/bob"))
+	assert.Equal(t, "acme/bob", errKey)
+}
+
+func TestHierarchicalLimiterRespectsLimitOnError(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	h := NewHierarchicalLimiter(&deadPool{addr: srv.Addr()}, []Level{
+		{Name: "tenant", Limits: []Limit{{Dur: time.Minute, Limit: 1}}},
+	})
+
+	assert.True(t, h.Limit("acme"))
+	h.LimitOnError = false
+	assert.False(t, h.Limit("acme"))
+}
+
+func TestJoinPathEscapesSeparators(t *testing.T) {
+	key := JoinPath("a/b", "c")
+	assert.Equal(t, "a%2Fb/c", key)
+
+	parts, err := splitPath(key, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/b", "c"}, parts)
+}
+
+func TestMiddlewareLimitsByHierarchicalKeyFunc(t *testing.T) {
+	h, srv := newTestHierarchy(t)
+	defer srv.Close()
+
+	h.Levels[0].Key = KeyByHeader("X-Tenant")
+	h.Levels[1].Key = KeyByHeader("X-User")
+	h.Levels[2].Key = KeyByIP
+	mw := Middleware(h, WithKeyFunc(h.KeyFunc()))(&fakeHandler{})
+
+	codes := make([]int, 3)
+	for i := range codes {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Tenant", "acme")
+		r.Header.Set("X-User", "bob")
+		mw.ServeHTTP(w, r)
+		codes[i] = w.Code
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+}
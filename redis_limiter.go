@@ -1,27 +1,49 @@
 package ratelimiter
 
 import (
+	"context"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 )
 
-var limiter = redis.NewScript(1, `
+var limiter = redis.NewScript(2, `
 local limit = tonumber(ARGV[1])
 local ttl = tonumber(ARGV[2])
-local k = "requests:" .. KEYS[1] .. ":" .. ARGV[2]
+local k = KEYS[1]
+if redis.call("EXISTS", KEYS[2]) == 1 then
+    return {2, redis.call("PTTL", KEYS[2])}
+end
 local current = redis.call("LLEN", k)
 if current >= limit then
-    return 1
+    return {1, redis.call("PTTL", k)}
 else
     if redis.call("EXISTS", k) == 1 then
-	redis.call("RPUSHX", k, 1)
+	redis.call("RPUSHX", k, ARGV[3])
     else
-	redis.call("RPUSH", k, 1)
+	redis.call("RPUSH", k, ARGV[3])
 	redis.call("EXPIRE", k, ttl)
     end
 end
+return {0, current}`)
+
+var refund = redis.NewScript(-1, `
+for _, k in ipairs(KEYS) do
+    redis.call("LREM", k, 1, ARGV[1])
+end
+return 0`)
+
+var peek = redis.NewScript(-1, `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+    return 1
+end
+for i = 2, #KEYS do
+    if redis.call("LLEN", KEYS[i]) >= tonumber(ARGV[i - 1]) then
+        return 1
+    end
+end
 return 0`)
 
 type redisPool interface {
@@ -38,9 +60,19 @@ func NewRedisLimiter(pool redisPool, limits []Limit) *RedisLimiter {
 		Pool:         pool,
 		Limits:       limits,
 		LimitOnError: true,
+		Clock:        SystemClock,
 	}
 }
 
+// NewStrictRedisLimiter creates a RedisLimiter like NewRedisLimiter but fails
+// when ValidateLimits finds any issues with limits
+func NewStrictRedisLimiter(pool redisPool, limits []Limit) (*RedisLimiter, error) {
+	if err := ValidateLimits(limits).Err(); err != nil {
+		return nil, err
+	}
+	return NewRedisLimiter(pool, limits), nil
+}
+
 // RedisLimiter is a rate limit which can evaluate an IP address to determine if it
 // should be rate limited using Redis as a backend
 type RedisLimiter struct {
@@ -48,33 +80,330 @@ type RedisLimiter struct {
 	Limits       []Limit
 	LimitOnError bool
 	OnError      func(ip string, err error)
+	Collector    Collector
+	Observer     Observer
+	Tracer       Tracer
+	// TraceKeySecret is the secret of the HMAC of keys attached to spans.
+	// Keys are left out of spans when it is empty
+	TraceKeySecret []byte
+	// Clock measures the sleeps of Wait. Windows expire in Redis and use
+	// the clock of the Redis server. SystemClock is used when Clock is nil
+	Clock Clock
 }
 
 // Limit checks an IP address to see if it should be ratelimited. It returns
 // true if the IP address should be ratelimited and false otherwise any errors
 // encountered will return *RedisLimiter.LimitOnError plus the error
 func (l *RedisLimiter) Limit(ip string) bool {
+	return l.LimitContext(context.Background(), ip)
+}
+
+// LimitContext checks an IP address the same way as Limit. Spans started by
+// Tracer are children of the span in ctx
+func (l *RedisLimiter) LimitContext(ctx context.Context, ip string) bool {
+	limited, _, _, err := l.check(ctx, ip, "1")
+	if err != nil {
+		if l.OnError != nil {
+			l.OnError(ip, err)
+		}
+
+		return l.LimitOnError
+	}
+	return limited
+}
+
+// LimitRetry checks an IP address the same way as Limit but also returns how
+// long the IP address must wait before it will be allowed again. Errors are
+// returned to the caller instead of being handled with LimitOnError and OnError
+func (l *RedisLimiter) LimitRetry(ip string) (bool, time.Duration, error) {
+	limited, retry, _, err := l.check(context.Background(), ip, "1")
+	return limited, retry, err
+}
+
+// Reserve checks an IP address the same way as Limit and returns a
+// Reservation which can give back the units that were consumed. Errors are
+// handled the same way as Limit
+func (l *RedisLimiter) Reserve(ip string) *Reservation {
+	r, err := l.reserveRetry(ip)
+	if err != nil {
+		return l.reserveError(ip, err)
+	}
+	return r
+}
+
+// reserveRetry works like Reserve but returns errors to the caller
+func (l *RedisLimiter) reserveRetry(ip string) (*Reservation, error) {
+	token, err := newReservationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	limited, retry, keys, err := l.check(context.Background(), ip, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reservation{
+		Limited:    limited,
+		RetryAfter: retry,
+		cancel: func() error {
+			if len(keys) == 0 {
+				return nil
+			}
+			con := l.Pool.Get()
+			defer con.Close()
+
+			args := redis.Args{len(keys)}.AddFlat(keys).Add(token)
+			if _, err := refund.Do(con, args...); err != nil {
+				err := fmt.Errorf("%s: %s", "failed to refund reservation", err)
+				l.reportError(ip, err)
+				return err
+			}
+			return nil
+		},
+	}, nil
+}
+
+func (l *RedisLimiter) reserveError(ip string, err error) *Reservation {
+	if l.OnError != nil {
+		l.OnError(ip, err)
+	}
+	return &Reservation{Limited: l.LimitOnError}
+}
+
+// check runs the limiter script for every limit, storing member for each unit
+// consumed. It returns the keys that a unit was consumed from
+func (l *RedisLimiter) check(ctx context.Context, ip, member string) (bool, time.Duration, []string, error) {
 	con := l.Pool.Get()
 	defer con.Close()
 
+	var consumed []string
+	remaining := -1
 	for _, limit := range l.Limits {
-		key := ip
-		if limit.Global {
-			key = "global"
-		}
-
-		limited, err := redis.Bool(limiter.Do(con, key, limit.Limit, limit.Dur.Seconds()))
+		key := limitKey(ip, limit)
+		res, err := l.runLimiter(ctx, con, ip, limit, member)
 		if err != nil {
 			err := fmt.Errorf("%s: %s", "failed to execute script", err)
-			if l.OnError != nil {
-				l.OnError(ip, err)
+			collectDecision(l.Collector, limit, DecisionErrored)
+			observe(l.Observer, Event{Type: EventError, Key: ip, Err: err})
+			return false, 0, consumed, err
+		}
+
+		switch res[0] {
+		case 0:
+			collectDecision(l.Collector, limit, DecisionAllowed)
+			consumed = append(consumed, key)
+			// the script returns the count before the unit was consumed
+			if left := limit.Limit - res[1] - 1; remaining < 0 || left < remaining {
+				remaining = left
+			}
+		case 2:
+			retry := time.Duration(res[1]) * time.Millisecond
+			if l.Collector != nil {
+				l.Collector.Decision("", DecisionBanned)
 			}
+			observe(l.Observer, Event{Type: EventDeny, Key: ip, RetryAfter: retry})
+			return true, retry, consumed, nil
+		default:
+			retry := time.Duration(res[1]) * time.Millisecond
+			collectDecision(l.Collector, limit, DecisionDenied)
+			observe(l.Observer, Event{Type: EventDeny, Key: ip, Limit: limit, RetryAfter: retry})
+			return true, retry, consumed, nil
+		}
+	}
+
+	observe(l.Observer, Event{Type: EventAllow, Key: ip, Remaining: remaining})
+	return false, 0, consumed, nil
+}
+
+// runLimiter runs the limiter script for a single limit and records its
+// latency and a span
+func (l *RedisLimiter) runLimiter(ctx context.Context, con redis.Conn, ip string, limit Limit, member string) ([]int, error) {
+	_, span := startSpan(ctx, l.Tracer, "ratelimiter.redis")
+	defer span.End()
 
-			return l.LimitOnError
+	start := time.Now()
+	res, err := redis.Ints(limiter.Do(con, limitKey(ip, limit), banKey(ip), limit.Limit, limit.Dur.Seconds(), member))
+	collectLatency(l.Collector, "redis", time.Since(start))
+
+	if l.Tracer != nil {
+		decision := DecisionErrored
+		if err == nil {
+			switch res[0] {
+			case 0:
+				decision = DecisionAllowed
+			case 2:
+				decision = DecisionBanned
+			default:
+				decision = DecisionDenied
+			}
 		}
-		if limited {
-			return true
+		span.SetAttributes(append(keyAttributes(l.TraceKeySecret, ip),
+			Attribute{Key: AttributeLimit, Value: limit.String()},
+			Attribute{Key: AttributeDecision, Value: string(decision)},
+			Attribute{Key: AttributeBackend, Value: "redis"},
+		)...)
+	}
+	return res, err
+}
+
+// reportError sends err to OnError and Observer
+func (l *RedisLimiter) reportError(ip string, err error) {
+	if l.OnError != nil {
+		l.OnError(ip, err)
+	}
+	observe(l.Observer, Event{Type: EventError, Key: ip, Err: err})
+}
+
+// Peek checks if an IP address would be ratelimited without consuming any
+// units. Errors are handled the same way as Limit
+func (l *RedisLimiter) Peek(ip string) bool {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	args := redis.Args{len(l.Limits) + 1, banKey(ip)}
+	for _, limit := range l.Limits {
+		args = args.Add(limitKey(ip, limit))
+	}
+	for _, limit := range l.Limits {
+		args = args.Add(limit.Limit)
+	}
+
+	start := time.Now()
+	limited, err := redis.Bool(peek.Do(con, args...))
+	collectLatency(l.Collector, "redis", time.Since(start))
+	if err != nil {
+		l.reportError(ip, fmt.Errorf("%s: %s", "failed to execute script", err))
+		return l.LimitOnError
+	}
+	return limited
+}
+
+// Consume takes a unit from every limit of an IP address which isn't already
+// exhausted. Errors are reported to OnError
+func (l *RedisLimiter) Consume(ip string) {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	for _, limit := range l.Limits {
+		_, err := limiter.Do(con, limitKey(ip, limit), banKey(ip), limit.Limit, limit.Dur.Seconds(), "1")
+		if err != nil {
+			l.reportError(ip, fmt.Errorf("%s: %s", "failed to execute script", err))
+			return
+		}
+	}
+}
+
+// Reset clears the counters of an IP address. Global limits are shared by
+// every IP address and are left untouched
+func (l *RedisLimiter) Reset(ip string) error {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	args := redis.Args{}
+	for _, limit := range l.Limits {
+		if !limit.Global {
+			args = args.Add(limitKey(ip, limit))
 		}
 	}
-	return false
+	if len(args) == 0 {
+		return nil
+	}
+
+	if _, err := con.Do("DEL", args...); err != nil {
+		return fmt.Errorf("%s: %s", "failed to reset counters", err)
+	}
+	observe(l.Observer, Event{Type: EventReset, Key: ip})
+	return nil
+}
+
+// Status returns the usage of an IP address for every limit
+func (l *RedisLimiter) Status(ip string) (Status, error) {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	banned, err := pttl(con, banKey(ip))
+	if err != nil {
+		return Status{}, fmt.Errorf("%s: %s", "failed to get ban", err)
+	}
+
+	status := Status{
+		Key:    ip,
+		Banned: banned,
+		Limits: make([]LimitStatus, 0, len(l.Limits)),
+	}
+	for _, limit := range l.Limits {
+		key := limitKey(ip, limit)
+		count, err := redis.Int(con.Do("LLEN", key))
+		if err != nil {
+			return Status{}, fmt.Errorf("%s: %s", "failed to get count", err)
+		}
+		reset, err := pttl(con, key)
+		if err != nil {
+			return Status{}, fmt.Errorf("%s: %s", "failed to get reset", err)
+		}
+		status.Limits = append(status.Limits, newLimitStatus(limit, count, reset))
+	}
+	return status, nil
+}
+
+// ResetAll clears the counters of every IP address. Counters are stored under
+// the same keys by every RedisLimiter so this also resets other limiters which
+// use the same Redis database
+func (l *RedisLimiter) ResetAll() error {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	// named limits are stored under requests@<name>
+	for _, pattern := range []string{"requests:*", "requests@*"} {
+		cursor := 0
+		for {
+			res, err := redis.Values(con.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+			if err != nil {
+				return fmt.Errorf("%s: %s", "failed to scan counters", err)
+			}
+
+			var keys []string
+			if _, err := redis.Scan(res, &cursor, &keys); err != nil {
+				return fmt.Errorf("%s: %s", "failed to scan counters", err)
+			}
+			if len(keys) > 0 {
+				if _, err := con.Do("DEL", redis.Args{}.AddFlat(keys)...); err != nil {
+					return fmt.Errorf("%s: %s", "failed to reset counters", err)
+				}
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	observe(l.Observer, Event{Type: EventReset})
+	return nil
+}
+
+// Ban limits an IP address for dur regardless of its usage. Bans are stored
+// under the same keys as RedisPenaltyStore
+func (l *RedisLimiter) Ban(ip string, dur time.Duration) error {
+	if err := NewRedisPenaltyStore(l.Pool).Ban(ip, dur); err != nil {
+		return err
+	}
+	observe(l.Observer, Event{Type: EventBan, Key: ip, RetryAfter: dur})
+	return nil
+}
+
+// Unban lifts the ban of an IP address and forgets the violations recorded by
+// RedisPenaltyStore
+func (l *RedisLimiter) Unban(ip string) error {
+	con := l.Pool.Get()
+	defer con.Close()
+
+	if _, err := con.Do("DEL", banKey(ip), violationsKey(ip)); err != nil {
+		return fmt.Errorf("%s: %s", "failed to unban", err)
+	}
+	return nil
+}
+
+// Wait blocks until ip is allowed by l. See Wait for details
+func (l *RedisLimiter) Wait(ctx context.Context, ip string) error {
+	return wait(ctx, clockOrSystem(l.Clock), l, ip)
 }
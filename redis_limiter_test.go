@@ -1,6 +1,7 @@
 package ratelimiter
 
 import (
+	"context"
 	"math/rand"
 	"testing"
 	"time"
@@ -109,6 +110,261 @@ func TestLimiterRespectsLimitOnError(t *testing.T) {
 	})
 }
 
+func TestLimiterLimitRetryReturnsTimeUntilReset(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := Limit{
+		Dur:   time.Minute,
+		Limit: 1,
+	}
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{l})
+	ip := uuid.New()
+
+	limited, retry, err := limiter.LimitRetry(ip)
+	require.NoError(t, err)
+	assert.False(t, limited)
+	assert.Zero(t, retry)
+
+	srv.FastForward(20 * time.Second)
+
+	limited, retry, err = limiter.LimitRetry(ip)
+	require.NoError(t, err)
+	assert.True(t, limited)
+	assert.Equal(t, 40*time.Second, retry)
+}
+
+func TestLimiterLimitRetryReturnsErrors(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&deadPool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+
+	_, _, err = limiter.LimitRetry(uuid.New())
+	assert.Error(t, err)
+}
+
+func TestLimiterWaitFailsWhenResetIsPastDeadline(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	ip := uuid.New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, limiter.Wait(ctx, ip))
+	assert.Equal(t, ErrWaitExceedsDeadline, limiter.Wait(ctx, ip))
+}
+
+func TestLimiterStoresCountersByIPAndDuration(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{
+		{Dur: time.Minute, Limit: 1},
+		{Dur: time.Hour, Limit: 1, Global: true},
+	})
+
+	assert.False(t, limiter.Limit("127.0.0.1"))
+	assert.ElementsMatch(t, []string{"requests:127.0.0.1:60", "requests:global:3600"}, srv.Keys())
+}
+
+func TestLimiterReservationCancelRefundsEveryLimit(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{
+		{Dur: time.Minute, Limit: 1},
+		{Dur: time.Hour, Limit: 1},
+	})
+	ip := uuid.New()
+
+	res := limiter.Reserve(ip)
+	assert.False(t, res.Limited)
+	require.NoError(t, res.Cancel())
+
+	assert.False(t, limiter.Limit(ip))
+	assert.True(t, limiter.Limit(ip))
+}
+
+func TestLimiterReservationCancelIgnoresNewWindows(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	ip := uuid.New()
+
+	res := limiter.Reserve(ip)
+	srv.FastForward(time.Minute)
+	assert.False(t, limiter.Limit(ip))
+
+	require.NoError(t, res.Cancel())
+	assert.True(t, limiter.Limit(ip))
+}
+
+func TestLimiterReserveRespectsLimitOnError(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	var result string
+	limiter := NewRedisLimiter(&deadPool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.OnError = func(ip string, err error) {
+		assert.Error(t, err)
+		result = ip
+	}
+	ip := uuid.New()
+
+	assert.True(t, limiter.Reserve(ip).Limited)
+	assert.Equal(t, ip, result)
+}
+
+func TestLimiterPeekDoesNotConsume(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{
+		{Dur: time.Minute, Limit: 1},
+		{Dur: time.Hour, Limit: 2},
+	})
+	ip := uuid.New()
+
+	assert.False(t, limiter.Peek(ip))
+	assert.False(t, limiter.Peek(ip))
+	limiter.Consume(ip)
+	assert.True(t, limiter.Peek(ip))
+}
+
+func TestLimiterResetKeepsGlobalLimits(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{
+		{Dur: time.Minute, Limit: 1},
+		{Dur: time.Minute, Limit: 2, Global: true},
+	})
+
+	limiter.Consume("a")
+	require.NoError(t, limiter.Reset("a"))
+
+	assert.False(t, limiter.Limit("a"))
+	assert.True(t, limiter.Limit("b"))
+}
+
+func TestLimiterPeekRespectsLimitOnError(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&deadPool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.OnError = func(ip string, err error) {
+		assert.Error(t, err)
+	}
+
+	assert.True(t, limiter.Peek(uuid.New()))
+}
+
+func TestLimiterStatusReportsUsage(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{
+		{Dur: time.Minute, Limit: 2},
+		{Dur: time.Hour, Limit: 5},
+	})
+	ip := uuid.New()
+
+	limiter.Limit(ip)
+	srv.FastForward(10 * time.Second)
+	require.NoError(t, limiter.Ban(ip, time.Minute))
+
+	status, err := limiter.Status(ip)
+	require.NoError(t, err)
+	assert.Equal(t, Status{
+		Key:    ip,
+		Banned: time.Minute,
+		Limits: []LimitStatus{
+			{Limit: Limit{Dur: time.Hour, Limit: 5}, Count: 1, Remaining: 4, Reset: time.Hour - 10*time.Second},
+			{Limit: Limit{Dur: time.Minute, Limit: 2}, Count: 1, Remaining: 1, Reset: 50 * time.Second},
+		},
+	}, status)
+}
+
+func TestLimiterBanLimitsUntilUnbanned(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 10}})
+	ip := uuid.New()
+
+	require.NoError(t, limiter.Ban(ip, time.Hour))
+
+	limited, retry, err := limiter.LimitRetry(ip)
+	require.NoError(t, err)
+	assert.True(t, limited)
+	assert.Equal(t, time.Hour, retry)
+	assert.True(t, limiter.Peek(ip))
+	assert.False(t, limiter.Limit(uuid.New()))
+
+	require.NoError(t, limiter.Unban(ip))
+	assert.False(t, limiter.Limit(ip))
+}
+
+func TestLimiterUnbanLiftsPenaltyBans(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	pool := &fakePool{addr: srv.Addr()}
+	limiter := NewRedisLimiter(pool, []Limit{{Dur: time.Minute, Limit: 10}})
+	store := NewRedisPenaltyStore(pool)
+	ip := uuid.New()
+
+	_, err = store.Violate(ip, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.Ban(ip, time.Hour))
+	require.NoError(t, limiter.Unban(ip))
+
+	banned, err := store.Banned(ip)
+	require.NoError(t, err)
+	assert.Zero(t, banned)
+	n, err := store.Violate(ip, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestLimiterResetAllClearsEveryCounter(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{
+		{Dur: time.Minute, Limit: 1},
+		{Dur: time.Minute, Limit: 1, Name: "login"},
+		{Dur: time.Hour, Limit: 10, Global: true},
+	})
+	require.NoError(t, limiter.Ban("c", time.Hour))
+
+	limiter.Limit("a")
+	limiter.Limit("b")
+	require.NoError(t, limiter.ResetAll())
+
+	assert.Equal(t, []string{"bans:c"}, srv.Keys())
+}
+
 type fakePool struct {
 	addr string
 }
@@ -136,3 +392,20 @@ func (f *deadPool) Get() redis.Conn {
 
 	return con
 }
+
+func TestLimiterWaitWithoutConstructor(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := &RedisLimiter{
+		Pool:   &fakePool{addr: srv.Addr()},
+		Limits: []Limit{{Dur: time.Minute, Limit: 1}},
+	}
+
+	require.NoError(t, limiter.Wait(context.Background(), "a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(t, ErrWaitExceedsDeadline, limiter.Wait(ctx, "a"))
+}
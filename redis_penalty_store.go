@@ -0,0 +1,93 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var violate = redis.NewScript(1, `
+local count = redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+return count`)
+
+// NewRedisPenaltyStore creates a properly initialized RedisPenaltyStore
+func NewRedisPenaltyStore(pool redisPool) *RedisPenaltyStore {
+	return &RedisPenaltyStore{
+		Pool: pool,
+	}
+}
+
+// RedisPenaltyStore is a PenaltyStore which uses Redis as a backend so bans
+// are shared between processes
+type RedisPenaltyStore struct {
+	Pool redisPool
+}
+
+// Banned returns how long key remains banned or zero if it isn't banned
+func (s *RedisPenaltyStore) Banned(key string) (time.Duration, error) {
+	con := s.Pool.Get()
+	defer con.Close()
+
+	banned, err := pttl(con, banKey(key))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", "failed to get ban", err)
+	}
+	return banned, nil
+}
+
+// Violate records a violation for key and returns how many violations key has
+func (s *RedisPenaltyStore) Violate(key string, decay time.Duration) (int, error) {
+	con := s.Pool.Get()
+	defer con.Close()
+
+	count, err := redis.Int(violate.Do(con, violationsKey(key), millis(decay)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", "failed to record violation", err)
+	}
+	return count, nil
+}
+
+// Ban bans key for dur. Bans shorter than a millisecond last a millisecond
+func (s *RedisPenaltyStore) Ban(key string, dur time.Duration) error {
+	if dur <= 0 {
+		return ErrInvalidBan
+	}
+
+	con := s.Pool.Get()
+	defer con.Close()
+
+	if _, err := con.Do("PSETEX", banKey(key), millis(dur), 1); err != nil {
+		return fmt.Errorf("%s: %s", "failed to ban", err)
+	}
+	return nil
+}
+
+// pttl returns how long until key expires or zero if it doesn't exist
+func pttl(con redis.Conn, key string) (time.Duration, error) {
+	ttl, err := redis.Int64(con.Do("PTTL", key))
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+// millis returns d in milliseconds rounded up, which is the precision of
+// Redis expiry
+func millis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// banKey returns the key which marks key as banned
+func banKey(key string) string {
+	return "bans:" + key
+}
+
+// violationsKey returns the key which counts the violations of key
+func violationsKey(key string) string {
+	return "violations:" + key
+}
@@ -0,0 +1,83 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisPenaltyStoreBansUntilExpired(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	s := NewRedisPenaltyStore(&fakePool{addr: srv.Addr()})
+	key := uuid.New()
+
+	banned, err := s.Banned(key)
+	require.NoError(t, err)
+	assert.Zero(t, banned)
+
+	require.NoError(t, s.Ban(key, time.Minute))
+	srv.FastForward(20 * time.Second)
+
+	banned, err = s.Banned(key)
+	require.NoError(t, err)
+	assert.Equal(t, 40*time.Second, banned)
+
+	srv.FastForward(40 * time.Second)
+	banned, err = s.Banned(key)
+	require.NoError(t, err)
+	assert.Zero(t, banned)
+}
+
+func TestRedisPenaltyStoreCountsViolationsUntilDecay(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	s := NewRedisPenaltyStore(&fakePool{addr: srv.Addr()})
+	key := uuid.New()
+
+	for i := 1; i <= 3; i++ {
+		n, err := s.Violate(key, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, i, n)
+		srv.FastForward(30 * time.Second)
+	}
+
+	srv.FastForward(time.Minute)
+	n, err := s.Violate(key, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestRedisPenaltyStoreReturnsErrors(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	s := NewRedisPenaltyStore(&deadPool{addr: srv.Addr()})
+
+	_, err = s.Banned("a")
+	assert.Error(t, err)
+	_, err = s.Violate("a", time.Minute)
+	assert.Error(t, err)
+	assert.Error(t, s.Ban("a", time.Minute))
+}
+
+func TestRedisPenaltyStoreBanRoundsUpToMillisecond(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	s := NewRedisPenaltyStore(&fakePool{addr: srv.Addr()})
+
+	require.NoError(t, s.Ban("a", time.Microsecond))
+	assert.Equal(t, time.Millisecond, srv.TTL(banKey("a")))
+	assert.Equal(t, ErrInvalidBan, s.Ban("a", 0))
+}
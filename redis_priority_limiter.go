@@ -0,0 +1,197 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// takePriority applies the same rules as PriorityLimiter.available to the
+// hashes of the current windows, which count the units of every class and
+// their total. It returns the index of the window which shed the class, or 0
+// and the units left once every window has given one
+var takePriority = redis.NewScript(-1, `
+local p = ARGV[1]
+local m = tonumber(ARGV[2])
+local shares = {}
+for j = 1, m do
+    shares[ARGV[1 + 2 * j]] = tonumber(ARGV[2 + 2 * j])
+end
+local n = #KEYS
+
+local function reserved(limit, q)
+    return math.floor((shares[q] or 0) * limit)
+end
+
+local remaining
+for i = 1, n do
+    local limit = tonumber(ARGV[2 + 2 * m + i])
+    local used = {}
+    local h = redis.call("HGETALL", KEYS[i])
+    for j = 1, #h / 2 do
+        used[h[2 * j - 1]] = tonumber(h[2 * j])
+    end
+
+    -- the unused shares of higher classes are checked before subtracting
+    -- so that no number goes below zero
+    local function borrowable(free)
+        for q in pairs(shares) do
+            if tonumber(q) > tonumber(p) then
+                local r, u = reserved(limit, q), used[q] or 0
+                if u < r then
+                    if r - u >= free then
+                        return 0
+                    end
+                    free = free - (r - u)
+                end
+            end
+        end
+        return free
+    end
+
+    local total = used["total"] or 0
+    local left = 0
+    if total < limit then
+        local free = limit - total
+        local r, u = reserved(limit, p), used[p] or 0
+        if u < r and r - u < free then
+            left = (r - u) + borrowable(free - (r - u))
+        elseif u < r then
+            left = free
+        else
+            left = borrowable(free)
+        end
+    end
+
+    if left <= 0 then
+        return {i, 0}
+    end
+    if remaining == nil or left - 1 < remaining then
+        remaining = left - 1
+    end
+end
+
+for i = 1, n do
+    redis.call("HINCRBY", KEYS[i], p, 1)
+    redis.call("HINCRBY", KEYS[i], "total", 1)
+    redis.call("PEXPIRE", KEYS[i], ARGV[2 + 2 * m + n + i])
+end
+return {0, remaining}`)
+
+// giveBackPriority returns the units of class ARGV[1] to windows which still
+// have them
+var giveBackPriority = redis.NewScript(-1, `
+for i = 1, #KEYS do
+    if tonumber(redis.call("HGET", KEYS[i], ARGV[1]) or "0") > 0 then
+        redis.call("HINCRBY", KEYS[i], ARGV[1], "-1")
+        redis.call("HINCRBY", KEYS[i], "total", "-1")
+    end
+end
+return 0`)
+
+// NewRedisPriorityLimiter creates a properly initialized PriorityLimiter which
+// counts Global in Redis so that every instance shares the same quota. Classes
+// are shed when Redis fails
+func NewRedisPriorityLimiter(pool redisPool, limiter Limiter, global []Limit, shares map[Priority]float64) *PriorityLimiter {
+	l := NewPriorityLimiter(limiter, global, shares)
+	l.Pool = pool
+	l.LimitOnError = true
+	return l
+}
+
+// takeShared works like take with the windows kept in Redis
+func (l *PriorityLimiter) takeShared(key string, p Priority) (bool, func()) {
+	if len(l.Global) == 0 {
+		return l.take(key, p)
+	}
+
+	now := l.Clock.Now()
+	keys, resets := l.sharedWindows(now)
+
+	args := make([]interface{}, 0, 2+len(keys)+2*len(l.Shares)+2*len(l.Global))
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	args = append(args, priorityField(p), len(l.Shares))
+	for q, share := range l.Shares {
+		args = append(args, priorityField(q), strconv.FormatFloat(share, 'g', -1, 64))
+	}
+	for _, limit := range l.Global {
+		args = append(args, limit.Limit)
+	}
+	for _, reset := range resets {
+		args = append(args, millis(reset.Sub(now)))
+	}
+
+	con := l.Pool.Get()
+	defer con.Close()
+
+	res, err := redis.Ints(takePriority.Do(con, append([]interface{}{len(keys)}, args...)...))
+	if err != nil {
+		l.reportError(key, fmt.Errorf("%s: %s", "failed to take priority quota", err))
+		return l.LimitOnError, func() {}
+	}
+	if i := res[0]; i > 0 {
+		observe(l.Observer, Event{Type: EventDeny, Key: key, Limit: l.Global[i-1], RetryAfter: resets[i-1].Sub(now)})
+		return true, nil
+	}
+
+	observe(l.Observer, Event{Type: EventAllow, Key: key, Remaining: res[1]})
+	return false, func() { l.giveBackShared(key, p, keys) }
+}
+
+// giveBackShared returns the units taken for class p from the windows at keys
+func (l *PriorityLimiter) giveBackShared(key string, p Priority, keys []string) {
+	args := make([]interface{}, 0, 2+len(keys))
+	args = append(args, len(keys))
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	args = append(args, priorityField(p))
+
+	con := l.Pool.Get()
+	defer con.Close()
+
+	if _, err := giveBackPriority.Do(con, args...); err != nil {
+		l.reportError(key, fmt.Errorf("%s: %s", "failed to give back priority quota", err))
+	}
+}
+
+// usedShared works like Used with the windows kept in Redis
+func (l *PriorityLimiter) usedShared(p Priority) ([]int, error) {
+	keys, _ := l.sharedWindows(l.Clock.Now())
+
+	con := l.Pool.Get()
+	defer con.Close()
+
+	used := make([]int, len(keys))
+	for i, k := range keys {
+		n, err := redis.Int(con.Do("HGET", k, priorityField(p)))
+		if err != nil && err != redis.ErrNil {
+			return nil, fmt.Errorf("%s: %s", "failed to get priority quota", err)
+		}
+		used[i] = n
+	}
+	return used, nil
+}
+
+// sharedWindows returns the keys and resets of the current windows of Global.
+// Windows start at multiples of their duration so that every instance uses the
+// same ones
+func (l *PriorityLimiter) sharedWindows(now time.Time) ([]string, []time.Time) {
+	keys := make([]string, len(l.Global))
+	resets := make([]time.Time, len(l.Global))
+	for i, limit := range l.Global {
+		n := now.UnixNano() / limit.Dur.Nanoseconds()
+		keys[i] = counterKey("priority", "", limit) + ":" + strconv.FormatInt(n, 10)
+		resets[i] = time.Unix(0, (n+1)*limit.Dur.Nanoseconds())
+	}
+	return keys, resets
+}
+
+// priorityField returns the field of class p in the hash of a window
+func priorityField(p Priority) string {
+	return strconv.Itoa(int(p))
+}
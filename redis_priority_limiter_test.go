@@ -0,0 +1,98 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisPriorityLimiter(srv *miniredis.Miniredis, limiter Limiter, limit int, shares map[Priority]float64) *PriorityLimiter {
+	return NewRedisPriorityLimiter(&fakePool{addr: srv.Addr()}, limiter, []Limit{{Dur: time.Minute, Limit: limit}}, shares)
+}
+
+func TestRedisPriorityLimiterSharesGlobalBetweenInstances(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	shares := map[Priority]float64{PriorityHigh: 0.3}
+	a := newTestRedisPriorityLimiter(srv, nil, 10, shares)
+	b := newTestRedisPriorityLimiter(srv, nil, 10, shares)
+
+	// low can't take the share of high on either instance
+	assert.Equal(t, 4, admit(a, "a", PriorityLow, 4))
+	assert.Equal(t, 3, admit(b, "b", PriorityLow, 10))
+	assert.Equal(t, 3, admit(a, "c", PriorityHigh, 10))
+	assert.Equal(t, []int{7}, b.Used(PriorityLow))
+	assert.Equal(t, []int{3}, b.Used(PriorityHigh))
+}
+
+func TestRedisPriorityLimiterGuaranteesShares(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	l := newTestRedisPriorityLimiter(srv, nil, 10, map[Priority]float64{
+		PriorityNormal:   0.2,
+		PriorityCritical: 0.2,
+	})
+
+	assert.Equal(t, 6, admit(l, "a", PriorityLow, 10))
+	assert.Equal(t, 2, admit(l, "b", PriorityNormal, 10))
+	assert.Equal(t, 2, admit(l, "c", PriorityCritical, 10))
+}
+
+func TestRedisPriorityLimiterResetsWindows(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	now := time.Unix(600, 0)
+	l := newTestRedisPriorityLimiter(srv, nil, 2, nil)
+	l.Clock = funcClock(func() time.Time { return now })
+	o := &recordingObserver{}
+	l.Observer = o
+
+	assert.Equal(t, 2, admit(l, "a", PriorityNormal, 3))
+	assert.Equal(t, time.Minute, o.events[2].RetryAfter)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, 2, admit(l, "a", PriorityNormal, 3))
+}
+
+func TestRedisPriorityLimiterGivesBackUnitsRejectedByLimiter(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	limiter := &fakeLimiter{
+		LimitFunc: func(key string) bool {
+			return key == "abuser"
+		},
+	}
+	l := newTestRedisPriorityLimiter(srv, limiter, 2, nil)
+
+	assert.Equal(t, 0, admit(l, "abuser", PriorityNormal, 5))
+	assert.Equal(t, 2, admit(l, "a", PriorityNormal, 5))
+}
+
+func TestRedisPriorityLimiterHandlesErrors(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	var errKey string
+	l := NewRedisPriorityLimiter(&deadPool{addr: srv.Addr()}, nil, []Limit{{Dur: time.Minute, Limit: 10}}, nil)
+	l.OnError = func(key string, err error) {
+		errKey = key
+	}
+
+	assert.True(t, l.LimitPriority("a", PriorityCritical))
+	assert.Equal(t, "a", errKey)
+
+	l.LimitOnError = false
+	assert.False(t, l.LimitPriority("a", PriorityCritical))
+}
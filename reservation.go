@@ -0,0 +1,50 @@
+package ratelimiter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Reserver is a Limiter which can return the units consumed by a request
+type Reserver interface {
+	Limiter
+	Reserve(key string) *Reservation
+}
+
+// Reservation is the result of Reserver.Reserve. It remembers the units that
+// were consumed for a key so they can be given back with Cancel
+type Reservation struct {
+	// Limited is true when the key should be ratelimited
+	Limited bool
+	// RetryAfter is how long the key must wait before it will be allowed
+	// again when Limited is true
+	RetryAfter time.Duration
+
+	cancel func() error
+	once   sync.Once
+}
+
+// Cancel gives back every unit the reservation consumed. Units are only given
+// back to the window they were taken from so cancelling after a window reset
+// has no effect. Calling Cancel more than once is a no-op
+func (r *Reservation) Cancel() error {
+	var err error
+	r.once.Do(func() {
+		if r.cancel != nil {
+			err = r.cancel()
+		}
+	})
+	return err
+}
+
+// newReservationToken creates a random value used to identify the units
+// consumed by a reservation
+func newReservationToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,38 @@
+package ratelimiter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReservationCancelOnlyRefundsOnce(t *testing.T) {
+	calls := 0
+	res := &Reservation{
+		cancel: func() error {
+			calls++
+			return nil
+		},
+	}
+
+	assert.NoError(t, res.Cancel())
+	assert.NoError(t, res.Cancel())
+	assert.Equal(t, 1, calls)
+}
+
+func TestReservationCancelReturnsRefundError(t *testing.T) {
+	expected := errors.New("boom")
+	res := &Reservation{
+		cancel: func() error {
+			return expected
+		},
+	}
+
+	assert.Equal(t, expected, res.Cancel())
+}
+
+func TestReservationCancelWithoutUnitsIsNoop(t *testing.T) {
+	res := &Reservation{}
+	assert.NoError(t, res.Cancel())
+}
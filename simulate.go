@@ -0,0 +1,250 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogFormat is the format of an access log read by ReadLog
+type LogFormat string
+
+const (
+	// LogCSV is a CSV file with a timestamp and a key in every row. A header
+	// row is skipped
+	LogCSV LogFormat = "csv"
+	// LogJSONL is a file with a JSON object per line with a "time" and a
+	// "key" field
+	LogJSONL LogFormat = "jsonl"
+	// LogCLF is a Common Log Format file. The key is the remote host
+	LogCLF LogFormat = "clf"
+)
+
+// clfTime is the layout of timestamps in the Common Log Format
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// ErrUnknownLogFormat is returned by ReadLog for unknown formats
+var ErrUnknownLogFormat = errors.New("unknown log format")
+
+// LogRecord is a request read from an access log
+type LogRecord struct {
+	Time time.Time
+	Key  string
+}
+
+// ReadLog reads every request from an access log. Timestamps in CSV and JSONL
+// are either RFC 3339 or seconds since the epoch
+func ReadLog(r io.Reader, format LogFormat) ([]LogRecord, error) {
+	switch format {
+	case LogCSV:
+		return readCSVLog(r)
+	case LogJSONL:
+		return readLines(r, parseJSONLRecord)
+	case LogCLF:
+		return readLines(r, parseCLFRecord)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownLogFormat, format)
+	}
+}
+
+func readCSVLog(r io.Reader) ([]LogRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	var records []LogRecord
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", "failed to read log", err)
+		}
+		if len(row) < 2 {
+			return nil, fmt.Errorf("line %d: expected a timestamp and a key", line)
+		}
+
+		t, err := parseLogTime(row[0])
+		if err != nil {
+			if line == 1 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		records = append(records, LogRecord{Time: t, Key: row[1]})
+	}
+}
+
+// readLines parses every non empty line of r with parse
+func readLines(r io.Reader, parse func(string) (LogRecord, error)) ([]LogRecord, error) {
+	var records []LogRecord
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		rec, err := parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %s", "failed to read log", err)
+	}
+	return records, nil
+}
+
+func parseJSONLRecord(line string) (LogRecord, error) {
+	var rec struct {
+		Time json.RawMessage `json:"time"`
+		Key  string          `json:"key"`
+	}
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		return LogRecord{}, err
+	}
+	if len(rec.Time) == 0 {
+		return LogRecord{}, errors.New("missing time")
+	}
+
+	raw := string(rec.Time)
+	var s string
+	if json.Unmarshal(rec.Time, &s) == nil {
+		raw = s
+	}
+	t, err := parseLogTime(raw)
+	if err != nil {
+		return LogRecord{}, err
+	}
+	return LogRecord{Time: t, Key: rec.Key}, nil
+}
+
+func parseCLFRecord(line string) (LogRecord, error) {
+	host := strings.SplitN(line, " ", 2)[0]
+	start := strings.IndexByte(line, '[')
+	end := strings.IndexByte(line, ']')
+	if host == "" || start < 0 || end < start {
+		return LogRecord{}, errors.New("malformed common log format line")
+	}
+
+	t, err := time.Parse(clfTime, line[start+1:end])
+	if err != nil {
+		return LogRecord{}, fmt.Errorf("%s: %s", "invalid timestamp", err)
+	}
+	return LogRecord{Time: t, Key: host}, nil
+}
+
+// parseLogTime parses an RFC 3339 timestamp or seconds since the epoch
+func parseLogTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(frac*float64(time.Second))).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return t, nil
+}
+
+// SimulationResult is the outcome of replaying requests with Simulate
+type SimulationResult struct {
+	// Requests is the number of requests replayed
+	Requests int
+	// Rejected is the number of requests which would have been limited
+	Rejected int
+	// RejectedBy is the number of rejected requests by Limit.String()
+	RejectedBy map[string]int
+	// Clients are the keys with rejected requests, most rejected first
+	Clients []SimulatedClient
+}
+
+// SimulatedClient is the outcome of a simulation for a single key
+type SimulatedClient struct {
+	Key           string
+	Requests      int
+	Rejected      int
+	FirstRejected time.Time
+}
+
+// Simulate replays records through a MemoryLimiter with limits in virtual
+// time, so the result only depends on the records and not on how long the
+// simulation takes. Records are replayed in order of their time
+func Simulate(records []LogRecord, limits []Limit) SimulationResult {
+	sorted := make([]LogRecord, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	res := SimulationResult{RejectedBy: make(map[string]int)}
+	clock := &virtualClock{}
+	l := NewMemoryLimiter(append([]Limit(nil), limits...))
+	l.Clock = clock
+	l.Observer = ObserverFunc(func(e Event) {
+		if e.Type == EventDeny {
+			res.RejectedBy[e.Limit.String()]++
+		}
+	})
+
+	clients := make(map[string]*SimulatedClient)
+	for _, rec := range sorted {
+		clock.now = rec.Time
+		c := clients[rec.Key]
+		if c == nil {
+			c = &SimulatedClient{Key: rec.Key}
+			clients[rec.Key] = c
+		}
+
+		res.Requests++
+		c.Requests++
+		if l.Limit(rec.Key) {
+			res.Rejected++
+			if c.Rejected == 0 {
+				c.FirstRejected = rec.Time
+			}
+			c.Rejected++
+		}
+	}
+
+	for _, c := range clients {
+		if c.Rejected > 0 {
+			res.Clients = append(res.Clients, *c)
+		}
+	}
+	sort.Slice(res.Clients, func(i, j int) bool {
+		a, b := res.Clients[i], res.Clients[j]
+		if a.Rejected != b.Rejected {
+			return a.Rejected > b.Rejected
+		}
+		return a.Key < b.Key
+	})
+	return res
+}
+
+// virtualClock is a Clock which only moves when Simulate moves it
+type virtualClock struct {
+	now time.Time
+}
+
+func (c *virtualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *virtualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.now.Add(d)
+	return ch
+}
@@ -0,0 +1,113 @@
+package ratelimiter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var simStart = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestReadLogCSV(t *testing.T) {
+	records, err := ReadLog(strings.NewReader(`timestamp,key
+2020-01-02T03:04:05Z,a
+1577934246.5,b
+`), LogCSV)
+	require.NoError(t, err)
+
+	assert.Equal(t, []LogRecord{
+		{Time: simStart, Key: "a"},
+		{Time: simStart.Add(1500 * time.Millisecond), Key: "b"},
+	}, records)
+}
+
+func TestReadLogJSONL(t *testing.T) {
+	records, err := ReadLog(strings.NewReader(`{"time":"2020-01-02T03:04:05Z","key":"a"}
+
+{"time":1577934246,"key":"b"}
+`), LogJSONL)
+	require.NoError(t, err)
+
+	assert.Equal(t, []LogRecord{
+		{Time: simStart, Key: "a"},
+		{Time: simStart.Add(time.Second), Key: "b"},
+	}, records)
+}
+
+func TestReadLogCLF(t *testing.T) {
+	records, err := ReadLog(strings.NewReader(
+		`127.0.0.1 - frank [02/Jan/2020:03:04:05 +0000] "GET /a HTTP/1.0" 200 2326
+10.0.0.1 - - [02/Jan/2020:04:04:05 +0100] "GET /b HTTP/1.1" 404 0 "-" "curl/7.0"
+`), LogCLF)
+	require.NoError(t, err)
+
+	require.Len(t, records, 2)
+	assert.Equal(t, "127.0.0.1", records[0].Key)
+	assert.True(t, simStart.Equal(records[0].Time))
+	assert.Equal(t, "10.0.0.1", records[1].Key)
+	assert.True(t, simStart.Equal(records[1].Time))
+}
+
+func TestReadLogReportsLine(t *testing.T) {
+	tests := map[LogFormat]string{
+		LogCSV:   "time,key\n1,a\nyesterday,b\n",
+		LogJSONL: "{\"time\":1,\"key\":\"a\"}\n{\"key\":\"b\"}\n",
+		LogCLF:   "1.1.1.1 - - [02/Jan/2020:03:04:05 +0000] \"GET /\"\nnot a log line\n",
+	}
+
+	for format, log := range tests {
+		t.Run(string(format), func(t *testing.T) {
+			_, err := ReadLog(strings.NewReader(log), format)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "line ")
+		})
+	}
+
+	_, err := ReadLog(strings.NewReader(""), LogFormat("xml"))
+	assert.True(t, errors.Is(err, ErrUnknownLogFormat))
+}
+
+func TestSimulateReplaysInVirtualTime(t *testing.T) {
+	var records []LogRecord
+	// a sends 5 requests per second for 3 seconds, b one per second
+	for s := 0; s < 3; s++ {
+		for i := 0; i < 5; i++ {
+			at := simStart.Add(time.Duration(s)*time.Second + time.Duration(i)*100*time.Millisecond)
+			records = append(records, LogRecord{Time: at, Key: "a"})
+		}
+		records = append(records, LogRecord{Time: simStart.Add(time.Duration(s) * time.Second), Key: "b"})
+	}
+
+	res := Simulate(records, MustParseLimits([]string{"3/1s", "100/1h"}))
+
+	assert.Equal(t, 18, res.Requests)
+	assert.Equal(t, 6, res.Rejected)
+	assert.Equal(t, map[string]int{"3/1s": 6}, res.RejectedBy)
+	assert.Equal(t, []SimulatedClient{{
+		Key:           "a",
+		Requests:      15,
+		Rejected:      6,
+		FirstRejected: simStart.Add(300 * time.Millisecond),
+	}}, res.Clients)
+}
+
+func TestSimulateSortsRecordsAndIsReproducible(t *testing.T) {
+	records := []LogRecord{
+		{Time: simStart.Add(2 * time.Minute), Key: "a"},
+		{Time: simStart, Key: "a"},
+		{Time: simStart.Add(time.Second), Key: "a"},
+	}
+	limits := MustParseLimits([]string{"1/1m"})
+
+	first := Simulate(records, limits)
+	second := Simulate(records, limits)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, first.Rejected)
+	assert.Equal(t, simStart.Add(time.Second), first.Clients[0].FirstRejected)
+	assert.Equal(t, simStart.Add(2*time.Minute), records[0].Time, "records are not modified")
+}
@@ -0,0 +1,72 @@
+package ratelimiter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Tracer starts spans around limit checks. It is deliberately small so it can
+// be bridged to OpenTelemetry or any other tracing system
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single operation started by a Tracer
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	End()
+}
+
+// Attribute is a key value pair attached to a Span
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Attribute keys set on spans
+const (
+	AttributeKey      = "ratelimiter.key"
+	AttributeLimit    = "ratelimiter.limit"
+	AttributeDecision = "ratelimiter.decision"
+	AttributeBackend  = "ratelimiter.backend"
+)
+
+// ContextLimiter is a Limiter which accepts a context, for example to attach
+// spans to the trace of a request
+type ContextLimiter interface {
+	Limiter
+	LimitContext(ctx context.Context, key string) bool
+}
+
+// startSpan starts a span with t or returns a span which does nothing if t is
+// nil
+func startSpan(ctx context.Context, t Tracer, name string) (context.Context, Span) {
+	if t == nil {
+		return ctx, nopSpan{}
+	}
+	return t.Start(ctx, name)
+}
+
+// keyAttributes returns the AttributeKey of a span for key. Keys are only
+// attached as an HMAC with secret so that IP addresses and other identifiers
+// can't be recovered from traces, and are left out when secret is empty
+func keyAttributes(secret []byte, key string) []Attribute {
+	if len(secret) == 0 {
+		return nil
+	}
+	return []Attribute{{Key: AttributeKey, Value: hashKey(secret, key)}}
+}
+
+// hashKey returns the HMAC-SHA256 of key with secret
+func hashKey(secret []byte, key string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...Attribute) {}
+func (nopSpan) End()                       {}
@@ -0,0 +1,153 @@
+package ratelimiter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisLimiterRecordsSpans(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	tracer := &recordingTracer{}
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.Tracer = tracer
+	limiter.TraceKeySecret = traceSecret
+
+	limiter.Limit("127.0.0.1")
+	limiter.Limit("127.0.0.1")
+
+	require.Len(t, tracer.spans, 2)
+	for i, decision := range []Decision{DecisionAllowed, DecisionDenied} {
+		span := tracer.spans[i]
+		assert.Equal(t, "ratelimiter.redis", span.name)
+		assert.True(t, span.ended)
+		assert.Equal(t, map[string]string{
+			AttributeKey:      hashKey(traceSecret, "127.0.0.1"),
+			AttributeLimit:    "1/1m0s",
+			AttributeDecision: string(decision),
+			AttributeBackend:  "redis",
+		}, span.attrs)
+	}
+}
+
+func TestRedisLimiterRecordsErroredSpans(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	tracer := &recordingTracer{}
+	limiter := NewRedisLimiter(&deadPool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.Tracer = tracer
+
+	limiter.Limit("127.0.0.1")
+
+	require.Len(t, tracer.spans, 1)
+	assert.Equal(t, string(DecisionErrored), tracer.spans[0].attrs[AttributeDecision])
+}
+
+func TestMiddlewareRecordsSpansAroundLimiter(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	tracer := &recordingTracer{}
+	limiter := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}})
+	limiter.Tracer = tracer
+	mw := Middleware(limiter, WithTracer(tracer), WithTraceKeySecret(traceSecret))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:22826"
+	mw(&fakeHandler{}).ServeHTTP(w, r)
+
+	require.Len(t, tracer.spans, 2)
+	parent, child := tracer.spans[0], tracer.spans[1]
+	assert.Equal(t, "ratelimiter.middleware", parent.name)
+	assert.Equal(t, map[string]string{
+		AttributeKey:      hashKey(traceSecret, "127.0.0.1"),
+		AttributeDecision: string(DecisionAllowed),
+	}, parent.attrs)
+	assert.Equal(t, "ratelimiter.redis", child.name)
+	assert.Equal(t, parent, child.parent)
+}
+
+func TestHashKeyDoesNotLeakKey(t *testing.T) {
+	h := hashKey(traceSecret, "127.0.0.1")
+	assert.Len(t, h, 64)
+	assert.NotContains(t, h, "127")
+	assert.Equal(t, h, hashKey(traceSecret, "127.0.0.1"))
+	assert.NotEqual(t, h, hashKey([]byte("other"), "127.0.0.1"))
+}
+
+func TestSpansLeaveOutKeyWithoutSecret(t *testing.T) {
+	tracer := &recordingTracer{}
+	mw := Middleware(&fakeLimiter{}, WithTracer(tracer))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	mw(&fakeHandler{}).ServeHTTP(httptest.NewRecorder(), r)
+
+	require.Len(t, tracer.spans, 1)
+	assert.Equal(t, map[string]string{
+		AttributeDecision: string(DecisionAllowed),
+	}, tracer.spans[0].attrs)
+}
+
+func TestMiddlewareRecordsSpansForRefundIfAndCountIf(t *testing.T) {
+	for _, opt := range []MiddlewareOption{
+		RefundIf(StatusIn(http.StatusBadRequest)),
+		CountIf(StatusIn(http.StatusBadRequest)),
+	} {
+		tracer := &recordingTracer{}
+		limiter := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}})
+		mw := Middleware(limiter, opt, WithTracer(tracer))
+
+		r := httptest.NewRequest("GET", "/", nil)
+		mw(&fakeHandler{}).ServeHTTP(httptest.NewRecorder(), r)
+
+		require.Len(t, tracer.spans, 1)
+		assert.Equal(t, "ratelimiter.middleware", tracer.spans[0].name)
+		assert.True(t, tracer.spans[0].ended)
+		assert.Equal(t, string(DecisionAllowed), tracer.spans[0].attrs[AttributeDecision])
+	}
+}
+
+var traceSecret = []byte("traceSecret")
+
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]string
+	ended  bool
+}
+
+type spanKey struct{}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attrs: map[string]string{}}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) End() {
+	s.ended = true
+}
@@ -0,0 +1,147 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrInvalidLimits is returned by strict constructors when ValidateLimits
+// finds issues
+var ErrInvalidLimits = errors.New("invalid limits")
+
+// IssueKind is the kind of problem found by ValidateLimits
+type IssueKind string
+
+const (
+	// IssueInvalid is a limit with a count below 1 or a duration below 1s
+	IssueInvalid IssueKind = "invalid"
+	// IssueDuplicate is a limit which is equal to another limit
+	IssueDuplicate IssueKind = "duplicate"
+	// IssueDominated is a limit which can never be reached because another
+	// limit of the same scope always denies requests first
+	IssueDominated IssueKind = "dominated"
+	// IssueGlobalTighter is a per key limit which can never be reached
+	// because a global limit allows fewer requests for all keys together
+	IssueGlobalTighter IssueKind = "global-tighter"
+)
+
+// LimitIssue is a problem with a limit found by ValidateLimits
+type LimitIssue struct {
+	Kind IssueKind
+	// Limit is the limit with the problem
+	Limit Limit
+	// Other is the limit which causes the problem, if any
+	Other Limit
+}
+
+func (i LimitIssue) String() string {
+	switch i.Kind {
+	case IssueInvalid:
+		return fmt.Sprintf("%s: count must be > 0 and duration >= 1s", i.Limit.String())
+	case IssueDuplicate:
+		return fmt.Sprintf("%s: duplicate limit", i.Limit.String())
+	case IssueDominated:
+		return fmt.Sprintf("%s: never reached because of %s", i.Limit.String(), i.Other.String())
+	case IssueGlobalTighter:
+		return fmt.Sprintf("%s: never reached because of global limit %s", i.Limit.String(), i.Other.String())
+	}
+	return fmt.Sprintf("%s: %s", i.Limit.String(), i.Kind)
+}
+
+// LimitReport is the result of ValidateLimits
+type LimitReport struct {
+	Issues []LimitIssue
+	// KeyRate is the number of requests per second a single key can sustain
+	// under the per key and global limits. It is +Inf without limits
+	KeyRate float64
+	// GlobalRate is the number of requests per second all keys together can
+	// sustain under the global limits. It is +Inf without global limits
+	GlobalRate float64
+}
+
+// Err returns nil when there are no issues and an error wrapping
+// ErrInvalidLimits which lists them otherwise
+func (r LimitReport) Err() error {
+	if len(r.Issues) == 0 {
+		return nil
+	}
+	s := make([]string, len(r.Issues))
+	for i, issue := range r.Issues {
+		s[i] = issue.String()
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidLimits, strings.Join(s, "; "))
+}
+
+// ValidateLimits checks a set of limits for duplicates and for limits which
+// can never be reached because of another limit, and computes the sustained
+// rates they allow. A longer limit is only reported when a shorter one denies
+// it even with perfectly spread requests
+func ValidateLimits(limits []Limit) LimitReport {
+	r := LimitReport{
+		KeyRate:    math.Inf(1),
+		GlobalRate: math.Inf(1),
+	}
+
+	for i, l := range limits {
+		if l.Limit < 1 || l.Dur.Seconds() < 1 {
+			r.Issues = append(r.Issues, LimitIssue{Kind: IssueInvalid, Limit: l})
+			continue
+		}
+
+		rate := float64(l.Limit) / l.Dur.Seconds()
+		r.KeyRate = math.Min(r.KeyRate, rate)
+		if l.Global {
+			r.GlobalRate = math.Min(r.GlobalRate, rate)
+		}
+
+		for j, other := range limits {
+			if i == j || other.Limit < 1 || other.Dur.Seconds() < 1 {
+				continue
+			}
+			if kind, ok := shadows(other, l, j < i); ok {
+				r.Issues = append(r.Issues, LimitIssue{Kind: kind, Limit: l, Other: other})
+				break
+			}
+		}
+	}
+
+	return r
+}
+
+// shadows reports whether a makes b impossible to reach. first is true when a
+// comes before b so that only one of two equal limits is reported. Named
+// limits and limits of different scopes have their own counters, so only a
+// global limit is compared with a limit of another scope
+func shadows(a, b Limit, first bool) (IssueKind, bool) {
+	if a.Name != b.Name {
+		return "", false
+	}
+	if a.Global && !b.Global {
+		// a single key always hits the global limit first
+		if a.Dur >= b.Dur && a.Limit <= b.Limit {
+			return IssueGlobalTighter, true
+		}
+		return "", false
+	}
+	if a.Global != b.Global || a.Network != b.Network {
+		return "", false
+	}
+
+	switch {
+	case a.Dur == b.Dur && a.Limit == b.Limit:
+		return IssueDuplicate, first
+	case a.Dur >= b.Dur && a.Limit <= b.Limit:
+		// a allows fewer requests in a longer window
+		return IssueDominated, true
+	case a.Dur < b.Dur:
+		// the most a lets through during b's window when requests are
+		// spread perfectly
+		windows := int64(math.Ceil(float64(b.Dur) / float64(a.Dur)))
+		if int64(b.Limit) > int64(a.Limit)*windows {
+			return IssueDominated, true
+		}
+	}
+	return "", false
+}
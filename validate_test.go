@@ -0,0 +1,109 @@
+package ratelimiter
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLimitsAcceptsSensibleLimits(t *testing.T) {
+	r := ValidateLimits(MustParseLimits([]string{"1/1s", "30/1m", "1000/24h", "100000/24h/g"}))
+
+	assert.Empty(t, r.Issues)
+	assert.NoError(t, r.Err())
+}
+
+func TestValidateLimitsOnlyComparesLimitsSharingACounter(t *testing.T) {
+	tests := [][]string{
+		{"10/1m", "1000/1m/net"},
+		{"login:5/1m", "api:100/1m"},
+	}
+
+	for _, limits := range tests {
+		r := ValidateLimits(MustParseLimits(limits))
+
+		assert.Empty(t, r.Issues, "%v", limits)
+	}
+}
+
+func TestValidateLimitsReportsIssues(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits []string
+		issue  LimitIssue
+	}{
+		{
+			name:   "duplicate",
+			limits: []string{"10/1m", "10/1m"},
+			issue:  LimitIssue{Kind: IssueDuplicate, Limit: MustParseLimit("10/1m"), Other: MustParseLimit("10/1m")},
+		},
+		{
+			name:   "same duration",
+			limits: []string{"10/1m", "1000/1m"},
+			issue:  LimitIssue{Kind: IssueDominated, Limit: MustParseLimit("1000/1m"), Other: MustParseLimit("10/1m")},
+		},
+		{
+			name:   "shorter limit never reached",
+			limits: []string{"100/1s", "10/1h"},
+			issue:  LimitIssue{Kind: IssueDominated, Limit: MustParseLimit("100/1s"), Other: MustParseLimit("10/1h")},
+		},
+		{
+			name:   "longer limit never reached",
+			limits: []string{"1/1s", "100/1m"},
+			issue:  LimitIssue{Kind: IssueDominated, Limit: MustParseLimit("100/1m"), Other: MustParseLimit("1/1s")},
+		},
+		{
+			name:   "global tighter",
+			limits: []string{"100/1m", "50/1h/g"},
+			issue:  LimitIssue{Kind: IssueGlobalTighter, Limit: MustParseLimit("100/1m"), Other: MustParseLimit("50/1h/g")},
+		},
+		{
+			name:   "invalid",
+			limits: nil,
+			issue:  LimitIssue{Kind: IssueInvalid, Limit: Limit{Limit: 0, Dur: time.Minute}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := MustParseLimits(tt.limits)
+			if tt.limits == nil {
+				limits = []Limit{tt.issue.Limit}
+			}
+
+			r := ValidateLimits(limits)
+
+			assert.Equal(t, []LimitIssue{tt.issue}, r.Issues)
+			assert.True(t, errors.Is(r.Err(), ErrInvalidLimits))
+		})
+	}
+}
+
+func TestValidateLimitsComputesSustainedRates(t *testing.T) {
+	r := ValidateLimits(MustParseLimits([]string{"10/1s", "60/1m", "3600/1h/g"}))
+
+	assert.Equal(t, 1.0, r.KeyRate)
+	assert.Equal(t, 1.0, r.GlobalRate)
+
+	r = ValidateLimits(MustParseLimits([]string{"120/1m"}))
+	assert.Equal(t, 2.0, r.KeyRate)
+	assert.True(t, math.IsInf(r.GlobalRate, 1))
+}
+
+func TestLimitIssueString(t *testing.T) {
+	issue := LimitIssue{Kind: IssueDominated, Limit: MustParseLimit("100/1s"), Other: MustParseLimit("10/1h")}
+	assert.Equal(t, "100/1s: never reached because of 10/1h0m0s", issue.String())
+}
+
+func TestNewStrictRedisLimiter(t *testing.T) {
+	_, err := NewStrictRedisLimiter(&fakePool{}, MustParseLimits([]string{"10/1m", "1000/1m"}))
+	assert.True(t, errors.Is(err, ErrInvalidLimits))
+
+	l, err := NewStrictRedisLimiter(&fakePool{}, MustParseLimits([]string{"1/1s", "10/1m"}))
+	require.NoError(t, err)
+	assert.Len(t, l.Limits, 2)
+}
@@ -0,0 +1,90 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrWaitExceedsDeadline is returned by Wait when the time a key has to wait
+// before it is allowed would pass the deadline of the context
+var ErrWaitExceedsDeadline = errors.New("wait would exceed context deadline")
+
+// minRetry is the shortest amount of time Wait sleeps between attempts. It
+// keeps Wait from spinning when a backend reports a key as limited without a
+// retry time
+const minRetry = 10 * time.Millisecond
+
+// RetryLimiter is a Limiter which can also report how long a limited key has
+// to wait before it will be allowed again
+type RetryLimiter interface {
+	Limiter
+	LimitRetry(key string) (limited bool, retryAfter time.Duration, err error)
+}
+
+// Wait blocks until l allows key. It sleeps for the retry time reported by l
+// between attempts and returns early with ctx.Err() if ctx is cancelled. If
+// ctx has a deadline and the retry time would pass it then Wait returns
+// ErrWaitExceedsDeadline immediately instead of sleeping. Backend errors are
+// returned as is.
+//
+// MemoryLimiter and RedisLimiter give back the units that a denied attempt
+// consumed from longer limits, so waiting doesn't use up a daily limit while
+// a per second limit denies. Other limiters are checked with LimitRetry
+func Wait(ctx context.Context, l RetryLimiter, key string) error {
+	return wait(ctx, SystemClock, l, key)
+}
+
+// wait is Wait with the sleeps between attempts measured by c
+func wait(ctx context.Context, c Clock, l RetryLimiter, key string) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		limited, retry, err := limitRetry(l, key)
+		if err != nil {
+			return err
+		}
+		if !limited {
+			return nil
+		}
+		if retry < minRetry {
+			retry = minRetry
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && deadline.Sub(c.Now()) < retry {
+			return ErrWaitExceedsDeadline
+		}
+
+		if !sleep(c, retry, ctx.Done()) {
+			return ctx.Err()
+		}
+	}
+}
+
+// retryReserver is a limiter whose checks can be given back and which
+// returns errors instead of handling them
+type retryReserver interface {
+	reserveRetry(key string) (*Reservation, error)
+}
+
+// limitRetry checks key with l. When l is a retryReserver the units consumed
+// by a denied check are given back
+func limitRetry(l RetryLimiter, key string) (bool, time.Duration, error) {
+	r, ok := l.(retryReserver)
+	if !ok {
+		return l.LimitRetry(key)
+	}
+
+	res, err := r.reserveRetry(key)
+	if err != nil {
+		return false, 0, err
+	}
+	if res.Limited {
+		if err := res.Cancel(); err != nil {
+			return false, 0, err
+		}
+	}
+	return res.Limited, res.RetryAfter, nil
+}
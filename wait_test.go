@@ -0,0 +1,148 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitReturnsImmediatelyWhenNotLimited(t *testing.T) {
+	l := &fakeRetryLimiter{}
+
+	err := Wait(context.Background(), l, "asdf")
+	require.NoError(t, err)
+	assert.Equal(t, 1, l.calls)
+}
+
+func TestWaitSleepsUntilAllowed(t *testing.T) {
+	l := &fakeRetryLimiter{
+		LimitRetryFunc: func(calls int) (bool, time.Duration, error) {
+			return calls < 3, 10 * time.Millisecond, nil
+		},
+	}
+
+	start := time.Now()
+	err := Wait(context.Background(), l, "asdf")
+	require.NoError(t, err)
+	assert.Equal(t, 3, l.calls)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+}
+
+func TestWaitFailsImmediatelyWhenRetryExceedsDeadline(t *testing.T) {
+	l := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return true, time.Hour, nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err := Wait(ctx, l, "asdf")
+	assert.Equal(t, ErrWaitExceedsDeadline, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestWaitReturnsContextErrorWhenCancelled(t *testing.T) {
+	l := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return true, time.Hour, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	err := Wait(ctx, l, "asdf")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestWaitReturnsLimiterErrors(t *testing.T) {
+	expected := errors.New("boom")
+	l := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return false, 0, expected
+		},
+	}
+
+	err := Wait(context.Background(), l, "asdf")
+	assert.Equal(t, expected, err)
+}
+
+type fakeRetryLimiter struct {
+	LimitRetryFunc func(calls int) (bool, time.Duration, error)
+	calls          int
+}
+
+func (f *fakeRetryLimiter) Limit(key string) bool {
+	limited, _, _ := f.LimitRetry(key)
+	return limited
+}
+
+func (f *fakeRetryLimiter) LimitRetry(key string) (bool, time.Duration, error) {
+	f.calls++
+	if f.LimitRetryFunc != nil {
+		return f.LimitRetryFunc(f.calls)
+	}
+	return false, 0, nil
+}
+
+func TestWaitMeasuresDeadlineWithClock(t *testing.T) {
+	l := &fakeRetryLimiter{
+		LimitRetryFunc: func(int) (bool, time.Duration, error) {
+			return true, 10 * time.Minute, nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	deadline, _ := ctx.Deadline()
+	clock := funcClock(func() time.Time { return deadline.Add(-time.Minute) })
+
+	err := wait(ctx, clock, l, "asdf")
+	assert.Equal(t, ErrWaitExceedsDeadline, err)
+}
+
+func TestWaitGivesBackUnitsOfLongerLimits(t *testing.T) {
+	c := &stepClock{now: time.Now()}
+	l := NewMemoryLimiter([]Limit{{Dur: time.Second, Limit: 1}, {Dur: time.Hour, Limit: 3}})
+	l.Clock = c
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Wait(context.Background(), "a"))
+	}
+
+	status, err := l.Status("a")
+	require.NoError(t, err)
+	assert.Equal(t, 3, status.Limits[0].Count, "denied attempts don't count against the hourly limit")
+	assert.Len(t, c.slept, 2)
+}
+
+func TestWaitGivesBackRedisUnitsOfLongerLimits(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	hour := Limit{Dur: time.Hour, Limit: 3}
+	l := NewRedisLimiter(&fakePool{addr: srv.Addr()}, []Limit{{Dur: time.Minute, Limit: 1}, hour})
+
+	limited, _, err := limitRetry(l, "a")
+	require.NoError(t, err)
+	assert.False(t, limited)
+
+	limited, retry, err := limitRetry(l, "a")
+	require.NoError(t, err)
+	assert.True(t, limited)
+	assert.True(t, retry > 0)
+
+	n, err := srv.List(limitKey("a", hour))
+	require.NoError(t, err)
+	assert.Len(t, n, 1)
+}
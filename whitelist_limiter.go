@@ -10,6 +10,8 @@ type WhitelistedLimiter struct {
 	Limiter     Limiter
 	Whitelist   []*net.IPNet
 	OnWhitelist func(ip string)
+	Collector   Collector
+	Observer    Observer
 }
 
 // ParseWhitelist parses a list of strings as CIDRs
@@ -36,14 +38,89 @@ func NewWhitelistedLimiter(limiter Limiter, whitelist []*net.IPNet) *Whitelisted
 // Limit checks the whitelist for whitelisted IP addresses and then return
 // false if any match. If none match then it defers to w.Limiter.Limit
 func (w *WhitelistedLimiter) Limit(ip string) bool {
-	for _, wl := range w.Whitelist {
-		if wl.Contains(net.ParseIP(ip)) {
-			if w.OnWhitelist != nil {
-				w.OnWhitelist(ip)
-			}
-			return false
-		}
+	if w.whitelisted(ip) {
+		return false
 	}
 
 	return w.Limiter.Limit(ip)
 }
+
+// NewWhitelistedReserver constructs a new WhitelistedReserver
+func NewWhitelistedReserver(reserver Reserver, whitelist []*net.IPNet) *WhitelistedReserver {
+	return &WhitelistedReserver{NewWhitelistedLimiter(reserver, whitelist)}
+}
+
+// WhitelistedReserver is a WhitelistedLimiter around a Reserver which is a
+// Reserver itself, so that it can be used with RefundIf. Limiter must
+// implement Reserver
+type WhitelistedReserver struct {
+	*WhitelistedLimiter
+}
+
+// Reserve returns a Reservation which is never limited for whitelisted IP
+// addresses. If none match then it defers to w.Limiter.Reserve
+func (w *WhitelistedReserver) Reserve(ip string) *Reservation {
+	if w.whitelisted(ip) {
+		return &Reservation{}
+	}
+	return w.Limiter.(Reserver).Reserve(ip)
+}
+
+// NewWhitelistedCounter constructs a new WhitelistedCounter
+func NewWhitelistedCounter(counter Counter, whitelist []*net.IPNet) *WhitelistedCounter {
+	return &WhitelistedCounter{NewWhitelistedLimiter(counter, whitelist)}
+}
+
+// WhitelistedCounter is a WhitelistedLimiter around a Counter which is a
+// Counter itself, so that it can be used with CountIf. Limiter must implement
+// Counter
+type WhitelistedCounter struct {
+	*WhitelistedLimiter
+}
+
+// Peek returns false for whitelisted IP addresses. If none match then it
+// defers to w.Limiter.Peek
+func (w *WhitelistedCounter) Peek(ip string) bool {
+	if w.whitelisted(ip) {
+		return false
+	}
+	return w.Limiter.(Counter).Peek(ip)
+}
+
+// Consume defers to w.Limiter.Consume for IP addresses which are not
+// whitelisted
+func (w *WhitelistedCounter) Consume(ip string) {
+	if !w.contains(ip) {
+		w.Limiter.(Counter).Consume(ip)
+	}
+}
+
+// Reset defers to w.Limiter.Reset
+func (w *WhitelistedCounter) Reset(ip string) error {
+	return w.Limiter.(Counter).Reset(ip)
+}
+
+// whitelisted reports whether ip is whitelisted and calls OnWhitelist if it is
+func (w *WhitelistedLimiter) whitelisted(ip string) bool {
+	if !w.contains(ip) {
+		return false
+	}
+	if w.OnWhitelist != nil {
+		w.OnWhitelist(ip)
+	}
+	if w.Collector != nil {
+		w.Collector.Decision("", DecisionWhitelisted)
+	}
+	observe(w.Observer, Event{Type: EventWhitelist, Key: ip})
+	return true
+}
+
+func (w *WhitelistedLimiter) contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	for _, wl := range w.Whitelist {
+		if wl.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package ratelimiter
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -56,3 +57,53 @@ func TestWhitelistedLimiterCallsOnWhitelistWhenWhitelisted(t *testing.T) {
 
 	assert.False(t, wl.Limit(ip.String()))
 }
+
+func TestWhitelistedReserverIgnoresLimiterWhenWhitelisted(t *testing.T) {
+	ip, cidr, err := net.ParseCIDR("192.168.1.100/32")
+	require.NoError(t, err)
+
+	wl := NewWhitelistedReserver(NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}}), []*net.IPNet{cidr})
+
+	assert.False(t, wl.Reserve(ip.String()).Limited)
+	assert.False(t, wl.Reserve(ip.String()).Limited)
+}
+
+func TestWhitelistedReserverDefersWhenNotWhitelisted(t *testing.T) {
+	wl := NewWhitelistedReserver(NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}}), nil)
+
+	assert.False(t, wl.Reserve("192.168.1.100").Limited)
+	assert.True(t, wl.Reserve("192.168.1.100").Limited)
+}
+
+func TestWhitelistedCounterPeekIgnoresLimiterWhenWhitelisted(t *testing.T) {
+	_, cidr, err := net.ParseCIDR("192.168.1.100/32")
+	require.NoError(t, err)
+	l := NewMemoryLimiter([]Limit{{Dur: time.Minute, Limit: 1}})
+
+	wl := NewWhitelistedCounter(l, []*net.IPNet{cidr})
+	wl.Consume("192.168.1.100")
+	wl.Consume("192.168.1.101")
+
+	assert.False(t, wl.Peek("192.168.1.100"))
+	assert.True(t, wl.Peek("192.168.1.101"))
+}
+
+func TestWhitelistedLimiterOnlyCountsWithCounter(t *testing.T) {
+	var l Limiter = NewWhitelistedLimiter(&fakeLimiter{}, nil)
+	_, ok := l.(Counter)
+	assert.False(t, ok, "Peek would have to consume a unit")
+
+	l = NewWhitelistedCounter(NewMemoryLimiter(nil), nil)
+	_, ok = l.(Counter)
+	assert.True(t, ok)
+}
+
+func TestWhitelistedLimiterOnlyReservesWithReserver(t *testing.T) {
+	var l Limiter = NewWhitelistedLimiter(&fakeLimiter{}, nil)
+	_, ok := l.(Reserver)
+	assert.False(t, ok, "Cancel would have nothing to give back")
+
+	l = NewWhitelistedReserver(NewMemoryLimiter(nil), nil)
+	_, ok = l.(Reserver)
+	assert.True(t, ok)
+}